@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Scopes an api key can be given
+const (
+	ScopeProfileRead  = "profile:read"
+	ScopeProfileWrite = "profile:write"
+	ScopeUploadPDF    = "upload:pdf"
+	ScopeUploadImage  = "upload:image"
+)
+
+const (
+	//apiKeyPrefix starts every api key so they are easy to tell apart
+	//from web tokens, and easy to find if accidentally committed
+	apiKeyPrefix = "mango_"
+
+	//How much of the key is kept in the clear to tell keys apart
+	apiKeyShownLength = len(apiKeyPrefix) + 6
+
+	maxAPIKeysPerUser = 20
+)
+
+var (
+	apiKeyScopes = []string{ScopeProfileRead, ScopeProfileWrite, ScopeUploadPDF, ScopeUploadImage}
+
+	//ErrUnknownScope if an api key is asked for a scope that doesn't exist
+	ErrUnknownScope = errors.New("Unknown scope, use " + strings.Join(apiKeyScopes, ", "))
+
+	//ErrTooManyAPIKeys if the user already has as many keys as allowed
+	ErrTooManyAPIKeys = fmt.Errorf("A user can have at most %d api keys", maxAPIKeysPerUser)
+)
+
+//APIKeyRequest is sent by the client to create an api key
+type APIKeyRequest struct {
+	Name   string
+	Scopes []string
+}
+
+//APIKeyResponse describes an api key. Key is only sent once, when created
+type APIKeyResponse struct {
+	ID       int64
+	Name     string
+	Prefix   string
+	Scopes   []string
+	Created  time.Time
+	LastUsed *time.Time `json:",omitempty"`
+	Key      string     `json:",omitempty"`
+}
+
+//newAPIKeyResponse describes a stored key without the key itself
+func newAPIKeyResponse(key *APIKey) APIKeyResponse {
+	response := APIKeyResponse{
+		ID:      key.ID,
+		Name:    key.Name,
+		Prefix:  key.Prefix,
+		Scopes:  key.Scopes,
+		Created: key.Created,
+	}
+	if !key.LastUsed.IsZero() {
+		response.LastUsed = &key.LastUsed
+	}
+	return response
+}
+
+//createAPIKey stores a new key for the user and returns it together with
+//the key itself, which is never stored and can't be shown again
+func createAPIKey(uid, name string, scopes []string) (*APIKey, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", ErrUnknownScope
+	}
+	for _, scope := range scopes {
+		if !containsString(apiKeyScopes, scope) {
+			return nil, "", ErrUnknownScope
+		}
+	}
+	existing, err := db.GetAPIKeys(uid)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(existing) >= maxAPIKeysPerUser {
+		return nil, "", ErrTooManyAPIKeys
+	}
+	if len(name) > 80 {
+		name = name[:80]
+	}
+
+	secret := apiKeyPrefix + randBase64String(32)
+	key := &APIKey{
+		UserID: uid,
+		Name:   name,
+		Prefix: secret[:apiKeyShownLength],
+		Hash:   hashToken(secret),
+		Scopes: scopes,
+	}
+	if err = db.InsertAPIKey(key); err != nil {
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+//handleAPIKey validates an api key sent instead of a web token. The key
+//has to have every one of the scopes, no scopes means the request can't
+//be made with an api key at all
+func handleAPIKey(w http.ResponseWriter, secret string, scopes []string) (*User, error) {
+	if len(scopes) == 0 {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("API keys can't be used for this, please log in"))
+		return nil, errors.New("API key used without scope")
+	}
+	key, err := db.UseAPIKey(hashToken(secret))
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Invalid api key"))
+		return nil, err
+	}
+	for _, scope := range scopes {
+		if !containsString(key.Scopes, scope) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("API key is missing the " + scope + " scope"))
+			return nil, errors.New("API key is missing the " + scope + " scope")
+		}
+	}
+	return &User{UserID: key.UserID, Token: secret}, nil
+}
+
+//containsString returns true if the value is in the list
+func containsString(list []string, value string) bool {
+	for _, entry := range list {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
+
+//Lists the api keys of the logged in user
+func getAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if !usingDatabase(w) {
+		return
+	}
+
+	user, err := handleToken(w, r) //feedback to client happens inside function
+	if err != nil {
+		return
+	}
+	keys, err := db.GetAPIKeys(user.UserID)
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to read api keys"))
+		return
+	}
+	response := make([]APIKeyResponse, len(keys))
+	for i, key := range keys {
+		response[i] = newAPIKeyResponse(key)
+	}
+	writeJSON(w, response)
+}
+
+//Creates an api key for the logged in user. The key is only sent this once
+func newAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !usingDatabase(w) {
+		return
+	}
+
+	user, err := handleToken(w, r) //feedback to client happens inside function
+	if err != nil {
+		return
+	}
+	request := new(APIKeyRequest)
+	if err = json.NewDecoder(r.Body).Decode(request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unable to read request"))
+		return
+	}
+
+	key, secret, err := createAPIKey(user.UserID, request.Name, request.Scopes)
+	if err == ErrUnknownScope || err == ErrTooManyAPIKeys {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to create api key"))
+		return
+	}
+	response := newAPIKeyResponse(key)
+	response.Key = secret
+	writeJSON(w, response)
+}
+
+//Revokes one of the logged in user's api keys. The key
+//id is the last part of the url: /api/keys/revoke/<id>
+func revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !usingDatabase(w) {
+		return
+	}
+
+	user, err := handleToken(w, r) //feedback to client happens inside function
+	if err != nil {
+		return
+	}
+	requestURLParts := strings.Split(r.RequestURI, "/")
+	id, err := strconv.ParseInt(requestURLParts[len(requestURLParts)-1], 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid api key id"))
+		return
+	}
+
+	err = db.RemoveAPIKey(user.UserID, id)
+	if err == ErrNoAPIKey {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("API key not found"))
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to revoke api key"))
+		return
+	}
+}
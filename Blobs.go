@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	//ErrNoBlob if nothing is stored at the path
+	ErrNoBlob = errors.New("No file is stored at the path")
+
+	//ErrUnknownBlobStore if no backend has been registered for the requested name
+	ErrUnknownBlobStore = errors.New("No blob store registered for the specified backend")
+
+	blobBackends = make(map[string]BlobStoreOpener)
+
+	//blobs holds the content of uploaded files. It is the www folder
+	//unless .blob_cnf says otherwise
+	blobs BlobStore = NewLocalBlobStore("www")
+
+	//uploadFolders are the folders of blobs, the file server leaves them to the blob store
+	uploadFolders = []string{"pdf/", "img/profile-headers/", "img/profile-icons/"}
+
+	//blobLocks serialize storing and releasing blobs, every path
+	//always gets the same lock
+	blobLocks [64]sync.Mutex
+)
+
+func init() {
+	RegisterBlobStore("local", func(cnf map[string]string) (BlobStore, error) {
+		root := cnf["ROOT"]
+		if root == "" {
+			root = "www"
+		}
+		return NewLocalBlobStore(root), nil
+	})
+}
+
+//BlobStore keeps the content of uploaded files. Paths are relative,
+//such as pdf/3f/a2/3fa2...e1.pdf, and always use forward slashes
+type BlobStore interface {
+	//Put stores size bytes of content at path. Hash is the hex
+	//encoded sha256 of the content
+	Put(path string, content io.Reader, size int64, hash string) error
+
+	//Stat returns the size of what is stored at path, or ErrNoBlob
+	Stat(path string) (int64, error)
+
+	//Remove removes what is stored at path, removing nothing is no error
+	Remove(path string) error
+
+	//List returns the paths of everything stored directly in folder
+	List(folder string) ([]string, error)
+
+	//ServeBlob answers a request for what is stored at path
+	ServeBlob(w http.ResponseWriter, r *http.Request, path string)
+}
+
+//BlobStoreOpener creates a BlobStore from the configurations read from .blob_cnf
+type BlobStoreOpener func(cnf map[string]string) (BlobStore, error)
+
+//RegisterBlobStore makes a backend available under the specified name.
+//Registering the same name twice replaces the previous backend
+func RegisterBlobStore(name string, opener BlobStoreOpener) {
+	blobBackends[strings.ToLower(name)] = opener
+}
+
+//OpenBlobStore opens the backend registered for the BACKEND
+//found in the provided configurations
+func OpenBlobStore(cnf map[string]string) (BlobStore, error) {
+	opener, ok := blobBackends[strings.ToLower(cnf["BACKEND"])]
+	if !ok {
+		return nil, ErrUnknownBlobStore
+	}
+	return opener(cnf)
+}
+
+//setupBlobStore replaces the local www folder with the blob store
+//configured in .blob_cnf, if there is one
+func setupBlobStore() {
+	conf, err := os.Open(".blob_cnf")
+	if err != nil {
+		return
+	}
+	cnf := readConfigurations(conf)
+	conf.Close()
+
+	store, err := OpenBlobStore(cnf)
+	if err != nil {
+		fmt.Println("Unable to open the blob store in .blob_cnf:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	blobs = store
+	fmt.Println("Storing uploads in " + cnf["BACKEND"] + " blob store")
+}
+
+//LocalBlobStore keeps blobs as files under a folder of the local disk
+type LocalBlobStore struct {
+	Root string
+}
+
+//NewLocalBlobStore returns a LocalBlobStore keeping its files under root
+func NewLocalBlobStore(root string) *LocalBlobStore {
+	return &LocalBlobStore{Root: root}
+}
+
+//file returns where the blob at path is kept on disk
+func (store *LocalBlobStore) file(path string) string {
+	return filepath.Join(store.Root, filepath.FromSlash(path))
+}
+
+//Put writes the content to a temporary file that is renamed into
+//place, so a file is never seen half written
+func (store *LocalBlobStore) Put(path string, content io.Reader, size int64, hash string) error {
+	target := store.file(path)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //Nothing to remove once it has been renamed
+
+	written, err := io.Copy(tmp, content)
+	if err == nil && written != size {
+		err = fmt.Errorf("Expected %d bytes, got %d", size, written)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+//Stat returns the size of the file at path
+func (store *LocalBlobStore) Stat(path string) (int64, error) {
+	info, err := os.Stat(store.file(path))
+	if os.IsNotExist(err) {
+		return 0, ErrNoBlob
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+//Remove removes the file at path
+func (store *LocalBlobStore) Remove(path string) error {
+	err := os.Remove(store.file(path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+//List returns the files directly in folder, leaving out hidden files
+//such as uploads that are still being written
+func (store *LocalBlobStore) List(folder string) ([]string, error) {
+	entries, err := os.ReadDir(store.file(folder))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			paths = append(paths, strings.TrimSuffix(folder, "/")+"/"+entry.Name())
+		}
+	}
+	return paths, nil
+}
+
+//ServeBlob serves the file at path
+func (store *LocalBlobStore) ServeBlob(w http.ResponseWriter, r *http.Request, path string) {
+	if _, err := store.Stat(path); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, store.file(path))
+}
+
+//putBlob stores the content in folder by its sha256 hash. The content is
+//read twice, once to hash it and once to store it. Content that is
+//already stored is not stored again. The blob is returned locked, so that
+//it can't be released before the upload has been recorded, and has to be
+//unlocked with the returned function
+func putBlob(folder, extension string, content io.ReadSeeker, size int64) (*Upload, func(), error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return nil, nil, err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	upload := &Upload{Path: blobPath(folder, sum, extension), Size: size, Hash: sum}
+	unlock := lockBlob(upload.Path)
+	_, err := blobs.Stat(upload.Path)
+	if err == ErrNoBlob {
+		err = blobs.Put(upload.Path, content, size, sum)
+	}
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	return upload, unlock, nil
+}
+
+//blobPath returns where content with the hash is stored in folder, spread
+//over two levels of folders so that none of them gets too large
+func blobPath(folder, hash, extension string) string {
+	return folder + hash[0:2] + "/" + hash[2:4] + "/" + hash + extension
+}
+
+//releaseBlob removes the blob at path once no user has it uploaded
+func releaseBlob(path string) error {
+	unlock := lockBlob(path)
+	defer unlock()
+	return removeUnusedBlob(path)
+}
+
+//removeUnusedBlob removes the blob at path if no user has it uploaded.
+//The blob has to be locked
+func removeUnusedBlob(path string) error {
+	count, err := db.CountUploads(path)
+	if err != nil || count > 0 {
+		return err
+	}
+	return blobs.Remove(path)
+}
+
+//lockBlob keeps anyone else from storing or releasing the blob at path
+//until the returned function is called
+func lockBlob(path string) func() {
+	hash := fnv.New32a()
+	hash.Write([]byte(path))
+	lock := &blobLocks[hash.Sum32()%uint32(len(blobLocks))]
+	lock.Lock()
+	return lock.Unlock
+}
+
+//Serves uploaded files from the blob store, the url is the path of the blob
+func serveUpload(w http.ResponseWriter, r *http.Request) {
+	blobPath := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	for _, folder := range uploadFolders {
+		if strings.HasPrefix(blobPath, folder) {
+			blobs.ServeBlob(w, r, blobPath)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
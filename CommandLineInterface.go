@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -22,7 +23,6 @@ func commandLineInterface(quit chan bool) {
 		default:
 			fmt.Print("mango> ")
 			text, _ := reader.ReadString('\n')
-			text = strings.ToLower(text)
 			text = strings.Replace(text, "\n", "", -1)
 			handle(text)
 		}
@@ -30,7 +30,12 @@ func commandLineInterface(quit chan bool) {
 }
 
 func handle(input string) {
-	switch input {
+	args := strings.Fields(input)
+	if len(args) == 0 {
+		return
+	}
+	//Only commands and their subcommands are case insensitive, arguments such as key ids are not
+	switch strings.ToLower(args[0]) {
 	case "help":
 		printCommands()
 		break
@@ -45,6 +50,24 @@ func handle(input string) {
 		break
 	case "uptime":
 		printUpTime()
+	case "migrate":
+		migrate(args[1:])
+	case "sessions":
+		sessions(args[1:])
+	case "keys":
+		keys(args[1:])
+	case "2fa":
+		twoFactor(args[1:])
+	case "lockouts":
+		lockouts(args[1:])
+	case "ratelimits":
+		printRateLimits()
+	case "apikeys":
+		apiKeys(args[1:])
+	case "invites":
+		invites(args[1:])
+	case "quota":
+		quota(args[1:])
 	default:
 		break
 	}
@@ -63,6 +86,18 @@ func printCommands() {
 	fmt.Println("\t help - print this help")
 	fmt.Println("\t version - show the current server version")
 	fmt.Println("\t uptime - show uptime for server")
+	fmt.Println("\t migrate [status|up|down|to <version>] - show or change the database schema version")
+	fmt.Println("\t sessions <email> - list the active sessions of a user")
+	fmt.Println("\t sessions revoke <email> <id> - log out one session of a user")
+	fmt.Println("\t keys [rotate [HS256|RS256|EdDSA]|retire <id>] - list, rotate or retire token signing keys")
+	fmt.Println("\t 2fa [disable] <email> - show or turn off two-factor authentication of a user")
+	fmt.Println("\t lockouts [clear <email|ip>|clear all] - list or clear failed logins and lockouts")
+	fmt.Println("\t ratelimits - show the rate limit and counters of every throttled route")
+	fmt.Println("\t apikeys <email> - list the api keys of a user")
+	fmt.Println("\t apikeys create <email> <scope,scope> [name] - create an api key for a user")
+	fmt.Println("\t apikeys revoke <email> <id> - revoke an api key of a user")
+	fmt.Println("\t invites [create <uses> [lifetime]|revoke <id>] - list, create or revoke invite codes")
+	fmt.Println("\t quota <email> [<size>|default] - show or change how much a user may upload")
 	fmt.Println("\t quit/exit - close the server")
 }
 
@@ -74,6 +109,145 @@ func printUpTime() {
 	fmt.Println(" ")
 }
 
+//migrate moves the database schema up, down or to a specific version
+func migrate(args []string) {
+	migrator, ok := db.(Migrator)
+	if !ok {
+		fmt.Println("Current storage has no schema to migrate")
+		return
+	}
+	current, err := migrator.SchemaVersion()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	target := current
+	switch command {
+	case "status":
+		fmt.Printf("Schema version: %d, latest: %d\n", current, migrator.LatestSchemaVersion())
+		return
+	case "up":
+		target = migrator.LatestSchemaVersion()
+	case "down":
+		target = current - 1
+	case "to":
+		if len(args) < 2 {
+			fmt.Println("Usage: migrate to <version>")
+			return
+		}
+		target, err = strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println("Version must be a number")
+			return
+		}
+	default:
+		fmt.Println("Unknown migrate command: " + command)
+		return
+	}
+
+	err = migrator.Migrate(target)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+//sessions lists or revokes the sessions of the user with the given email
+func sessions(args []string) {
+	if db == nil {
+		fmt.Println("No database associated")
+		return
+	}
+	if len(args) == 0 || (args[0] == "revoke" && len(args) != 3) {
+		fmt.Println("Usage: sessions <email> or sessions revoke <email> <id>")
+		return
+	}
+
+	email := args[0]
+	if args[0] == "revoke" {
+		email = args[1]
+	}
+	user, err := db.LookupUser(&User{Email: email})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if args[0] == "revoke" {
+		id, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			fmt.Println("Session id must be a number")
+			return
+		}
+		err = db.RemoveUserSessionByID(user.UserID, id)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Session revoked")
+		return
+	}
+
+	userSessions, err := db.GetUserSessions(user.UserID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, session := range userSessions {
+		fmt.Printf("%d\t%s\t%s\tlast seen %s\n", session.ID, session.IP, session.UserAgent, session.LastSeen.Format(time.RFC822))
+	}
+	if len(userSessions) == 0 {
+		fmt.Println("No active sessions")
+	}
+}
+
+//keys lists, rotates or retires the keys web tokens are signed with
+func keys(args []string) {
+	if len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "rotate":
+			algorithm := *keyAlg
+			if len(args) > 1 {
+				algorithm = args[1]
+			}
+			key, err := keyRing.Rotate(algorithm)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Println("Now signing with key " + key.ID)
+			fmt.Println("Retire the old key when its tokens have expired")
+		case "retire":
+			if len(args) != 2 {
+				fmt.Println("Usage: keys retire <id>")
+				return
+			}
+			err := keyRing.Retire(args[1])
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Println("Key retired")
+		default:
+			fmt.Println("Unknown keys command: " + args[0])
+		}
+		return
+	}
+
+	signing := keyRing.SigningKey()
+	for _, key := range keyRing.Keys() {
+		status := "validating"
+		if key == signing {
+			status = "signing"
+		}
+		fmt.Printf("%s\t%s\t%s\tcreated %s\n", key.ID, key.Algorithm, status, key.Created.Format(time.RFC822))
+	}
+}
+
 func catchCtrlC() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
@@ -84,3 +258,251 @@ func catchCtrlC() {
 		closeServer()
 	}()
 }
+
+//twoFactor shows whether a user has two-factor authentication turned on,
+//or turns it off for users who lost both their device and recovery codes
+func twoFactor(args []string) {
+	if db == nil {
+		fmt.Println("No database associated")
+		return
+	}
+	if len(args) == 0 || (args[0] == "disable" && len(args) != 2) {
+		fmt.Println("Usage: 2fa <email> or 2fa disable <email>")
+		return
+	}
+
+	user, err := db.LookupUser(&User{Email: args[len(args)-1]})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if args[0] == "disable" {
+		if err = db.RemoveTwoFactor(user.UserID); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Two-factor authentication turned off")
+		return
+	}
+
+	tf, err := db.GetTwoFactor(user.UserID)
+	switch {
+	case err == ErrNoTwoFactor:
+		fmt.Println("Two-factor authentication is off")
+	case err != nil:
+		fmt.Println(err)
+	case tf.Enabled:
+		fmt.Println("Two-factor authentication is on since " + tf.Created.Format(time.RFC822))
+	default:
+		fmt.Println("Two-factor authentication is being set up")
+	}
+}
+
+//lockouts lists the accounts and addresses with failed logins or clears them
+func lockouts(args []string) {
+	if len(args) > 0 {
+		if strings.ToLower(args[0]) != "clear" || len(args) != 2 {
+			fmt.Println("Usage: lockouts, lockouts clear <email|ip> or lockouts clear all")
+			return
+		}
+		if args[1] == "all" {
+			loginGuard.ClearAll()
+			fmt.Println("Every lockout cleared")
+			return
+		}
+		//Addresses never contain an @ so there is no need to ask which one is meant
+		if loginGuard.Clear(accountKey(args[1])) || loginGuard.Clear(addressKey(args[1])) {
+			fmt.Println("Lockout cleared")
+		} else {
+			fmt.Println("No failed logins for " + args[1])
+		}
+		return
+	}
+
+	list := loginGuard.Lockouts()
+	if len(list) == 0 {
+		fmt.Println("No failed logins")
+		return
+	}
+	for _, lockout := range list {
+		status := ""
+		if time.Now().Before(lockout.LockedUntil) {
+			status = "\tlocked until " + lockout.LockedUntil.Format("15:04:05")
+		}
+		fmt.Printf("%s\t%d failed\tlast %s%s\n", lockout.Key, lockout.Failures, lockout.LastFailure.Format(time.RFC822), status)
+	}
+}
+
+//printRateLimits shows how often every throttled route has said no
+func printRateLimits() {
+	for _, counters := range rateCounters() {
+		fmt.Printf("%s\t%s\tallowed %d\tlimited %d\tactive %d\n", counters.Name, counters.Limit, counters.Allowed, counters.Limited, counters.Buckets)
+	}
+}
+
+//apiKeys lists, creates or revokes the api keys of a user
+func apiKeys(args []string) {
+	if db == nil {
+		fmt.Println("No database associated")
+		return
+	}
+	command := ""
+	if len(args) > 0 {
+		command = strings.ToLower(args[0])
+	}
+	if len(args) == 0 || (command == "create" && len(args) < 3) || (command == "revoke" && len(args) != 3) {
+		fmt.Println("Usage: apikeys <email>, apikeys create <email> <scope,scope> [name] or apikeys revoke <email> <id>")
+		return
+	}
+
+	email := args[0]
+	if command == "create" || command == "revoke" {
+		email = args[1]
+	}
+	user, err := db.LookupUser(&User{Email: email})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	switch command {
+	case "create":
+		key, secret, err := createAPIKey(user.UserID, strings.Join(args[3:], " "), strings.Split(args[2], ","))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("Created api key %d, it is only shown this once:\n%s\n", key.ID, secret)
+	case "revoke":
+		id, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			fmt.Println("API key id must be a number")
+			return
+		}
+		if err = db.RemoveAPIKey(user.UserID, id); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("API key revoked")
+	default:
+		keys, err := db.GetAPIKeys(user.UserID)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		for _, key := range keys {
+			lastUsed := "never used"
+			if !key.LastUsed.IsZero() {
+				lastUsed = "last used " + key.LastUsed.Format(time.RFC822)
+			}
+			fmt.Printf("%d\t%s...\t%s\t%s\t%s\n", key.ID, key.Prefix, key.Name, strings.Join(key.Scopes, ","), lastUsed)
+		}
+		if len(keys) == 0 {
+			fmt.Println("No api keys")
+		}
+	}
+}
+
+//invites lists, creates or revokes the codes needed to register
+//when registration is invite only
+func invites(args []string) {
+	if db == nil {
+		fmt.Println("No database associated")
+		return
+	}
+	command := ""
+	if len(args) > 0 {
+		command = strings.ToLower(args[0])
+	}
+
+	switch {
+	case command == "create" && (len(args) == 2 || len(args) == 3):
+		uses, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println("Uses must be a number")
+			return
+		}
+		var lifetime time.Duration
+		if len(args) == 3 {
+			if lifetime, err = parseLifetime(args[2]); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		code, secret, err := createInviteCode(uses, lifetime)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("Created invite code %d, it is only shown this once:\n%s\n", code.ID, secret)
+		if registrationPolicy().Mode != RegistrationInvite {
+			fmt.Println("Registration is not invite only, start the server with -registration invite")
+		}
+	case command == "revoke" && len(args) == 2:
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fmt.Println("Invite code id must be a number")
+			return
+		}
+		if err = db.RemoveInviteCode(id); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Invite code revoked")
+	case len(args) == 0:
+		codes, err := db.GetInviteCodes()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		for _, code := range codes {
+			expires := "never expires"
+			if !code.ExpiresAt.IsZero() {
+				expires = "expires " + code.ExpiresAt.Format(time.RFC822)
+			}
+			fmt.Printf("%d\t%s...\tused %d of %d\t%s\n", code.ID, code.Prefix, code.Uses, code.MaxUses, expires)
+		}
+		if len(codes) == 0 {
+			fmt.Println("No invite codes")
+		}
+	default:
+		fmt.Println("Usage: invites, invites create <uses> [lifetime] or invites revoke <id>")
+	}
+}
+
+//quota shows how much a user has uploaded, or changes how much they may
+func quota(args []string) {
+	if db == nil {
+		fmt.Println("No database associated")
+		return
+	}
+	if len(args) != 1 && len(args) != 2 {
+		fmt.Println("Usage: quota <email>, quota <email> <size> or quota <email> default")
+		return
+	}
+	user, err := db.LookupUser(&User{Email: args[0]})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if len(args) == 2 {
+		size := ByteSize(-1)
+		if strings.ToLower(args[1]) != "default" {
+			if err = size.Set(args[1]); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		if err = db.SetStorageQuota(user.UserID, int64(size)); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	usage, err := storageUsage(user.UserID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("%s of %s used\n", ByteSize(usage.Used), ByteSize(usage.Quota))
+}
@@ -1,10 +1,7 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -23,83 +20,160 @@ var (
 
 	//ErrNoContentInDatabase if no content was found for the user
 	ErrNoContentInDatabase = errors.New("No content in database for the specified user")
+
+	//ErrNoRefreshToken if the refresh token is unknown or its session has been removed
+	ErrNoRefreshToken = errors.New("Refresh token was not found")
+
+	//ErrNoOneTimeToken if the token is unknown, issued for something else or already used
+	ErrNoOneTimeToken = errors.New("Token was not found or has already been used")
+
+	//ErrNoTwoFactor if the user has not set up two-factor authentication
+	ErrNoTwoFactor = errors.New("Two-factor authentication is not set up for the user")
+
+	//ErrTwoFactorReplayed if a code from a time step that has already been used is given again
+	ErrTwoFactorReplayed = errors.New("Two-factor code has already been used")
+
+	//ErrNoRecoveryCode if the recovery code is unknown or already used
+	ErrNoRecoveryCode = errors.New("Recovery code was not found or has already been used")
+
+	//ErrRefreshTokenReused if a refresh token that has already been used is presented again
+	ErrRefreshTokenReused = errors.New("Refresh token has already been used")
+
+	//ErrNoExternalIdentity if no user is linked to the account at the identity provider
+	ErrNoExternalIdentity = errors.New("No user is linked to the external account")
+
+	//ErrNoAPIKey if the api key is unknown or has been revoked
+	ErrNoAPIKey = errors.New("API key was not found")
+
+	//ErrNoInviteCode if the invite code is unknown, expired or used up
+	ErrNoInviteCode = errors.New("Invite code was not found, has expired or has been used up")
+
+	//ErrNoUpload if the user has not uploaded the file at the path
+	ErrNoUpload = errors.New("No upload was found for the file")
+
+	//ErrNoStorageQuota if the user has no quota of their own and the default applies
+	ErrNoStorageQuota = errors.New("User has the default storage quota")
 )
 
+func init() {
+	RegisterStorage("mysql", openSQL)
+	dialects["mysql"] = &sqlDialect{
+		driver:     "mysql",
+		migrations: "mysql",
+		connectionString: func(dbi *DatabaseInterface) string {
+			return dbi.User + ":" + dbi.Password + "@" + dbi.DataSourceName
+		},
+	}
+}
+
+//dialects holds the sql databases DatabaseInterface knows how to talk to,
+//keyed by the drivername given in .db_cnf
+var dialects = make(map[string]*sqlDialect)
+
+//sqlDialect describes what differs between the sql databases we support
+type sqlDialect struct {
+	driver           string //Name the driver is registered as in database/sql
+	migrations       string //Directory under migrations/ holding the schema
+	maxOpenConns     int    //Zero means unlimited
+	connectionString func(dbi *DatabaseInterface) string
+}
+
 //DatabaseInterface represent a configuration object, containing configurations
-// for the current database
+// for the current database. It implements Storage on top of any of the sql
+// databases found in dialects
 type DatabaseInterface struct {
 	User           string
 	Password       string
 	DriverName     string
 	DataSourceName string
+	AutoMigrate    bool
 	DB             *sql.DB
+	dialect        *sqlDialect
+	migrations     []*Migration
 }
 
 //SetConfigurations reads the specified config file
 //and sets the respective fields in the DatabaseInterface
 func (dbi *DatabaseInterface) SetConfigurations(f *os.File) {
-	cnf := make(map[string]string)
+	dbi.setConfigurationMap(readConfigurations(f))
+}
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		if data := scanner.Text(); strings.Contains(data, " ") {
-			insert := strings.Split(data, " ")
-			cnf[strings.ToUpper(insert[0])] = insert[1]
-		}
-	}
+//setConfigurationMap sets the fields of the DatabaseInterface
+//from already parsed configurations
+func (dbi *DatabaseInterface) setConfigurationMap(cnf map[string]string) {
 	dbi.User = cnf["USER"]
 	dbi.Password = cnf["PASSWORD"]
 	dbi.DriverName = cnf["DRIVERNAME"]
 	dbi.DataSourceName = cnf["DATASOURCENAME"]
+	dbi.AutoMigrate = strings.ToLower(cnf["AUTOMIGRATE"]) == "true"
 }
 
 //OpenConnection connects to a database using the information
-//provided in DatabaseInterface
+//provided in DatabaseInterface. Pending migrations are only applied if
+//AutoMigrate is set, otherwise they give ErrSchemaBehind. A schema newer
+//than the server gives ErrSchemaAhead
 func (dbi *DatabaseInterface) OpenConnection() error {
-	db, err := sql.Open(dbi.DriverName, dbi.getConnectionString())
+	dialect, ok := dialects[strings.ToLower(dbi.DriverName)]
+	if !ok {
+		return ErrUnknownStorage
+	}
+	dbi.dialect = dialect
+	migrations, err := loadMigrations(dialect.migrations)
 	if err != nil {
 		return err
 	}
+	dbi.migrations = migrations
+
+	db, err := sql.Open(dialect.driver, dbi.getConnectionString())
+	if err != nil {
+		return err
+	}
+	if dialect.maxOpenConns > 0 {
+		db.SetMaxOpenConns(dialect.maxOpenConns)
+	}
 	err = db.Ping()
 	if err != nil {
 		return err
 	}
 	dbi.DB = db
 
-	//Setup tables, if tables already exists, sql api will just throw away the query
-	dbi.DB.Exec("CREATE TABLE `UserSession` (`SessionKey` varchar(512) COLLATE utf8_unicode_ci NOT NULL,`UserId` varchar(128) COLLATE utf8_unicode_ci NOT NULL,`LoginTime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,`LastSeenTime` timestamp NOT NULL DEFAULT '0000-00-00 00:00:00') ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;")
-	dbi.DB.Exec("CREATE TABLE `Users` (`EMail` varchar(80) COLLATE utf8_unicode_ci NOT NULL,`UserId` varchar(128) COLLATE utf8_unicode_ci DEFAULT '',`Password` varchar(512) COLLATE utf8_unicode_ci DEFAULT '',`PasswordSalt` varchar(512) COLLATE utf8_unicode_ci DEFAULT NULL,PRIMARY KEY (`EMail`),UNIQUE KEY `EMail` (`EMail`)) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;")
-	dbi.DB.Exec("CREATE TABLE `UserContent` (`UserId` varchar(128) COLLATE utf8_unicode_ci NOT NULL,`FullName` varchar(70) COLLATE utf8_unicode_ci NOT NULL,`Phone` varchar(50) COLLATE utf8_unicode_ci NOT NULL,`EMail` varchar(80) COLLATE utf8_unicode_ci NOT NULL,`ProfileIcon` varchar(150) COLLATE utf8_unicode_ci NOT NULL,`ProfileHeader` varchar(150) COLLATE utf8_unicode_ci NOT NULL,`Description` varchar(360) COLLATE utf8_unicode_ci NOT NULL,`PublicName` varchar(80) COLLATE utf8_unicode_ci NOT NULL,`PDFs` varchar(20000) COLLATE utf8_unicode_ci NOT NULL DEFAULT '') ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;")
-	return nil
+	version, err := dbi.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > dbi.LatestSchemaVersion() {
+		db.Close()
+		return ErrSchemaAhead
+	}
+	if version < dbi.LatestSchemaVersion() && !dbi.AutoMigrate {
+		db.Close()
+		fmt.Printf("Database schema is at version %d of %d\n", version, dbi.LatestSchemaVersion())
+		return ErrSchemaBehind
+	}
+	return dbi.Migrate(dbi.LatestSchemaVersion())
 }
 
-//UniversalLookup will query the database for the given string. It will
-//search through all the rows and columns in every table in the database.
-//This function should be considered expensive and be used with caution.
-func (dbi *DatabaseInterface) UniversalLookup(phrase string) (bool, error) {
-	//Were hardcoding the database structure into a map
-	// in order to boost performance by reducing sql-queries
-	tables := make(map[string][]string)
-	tables["Users"] = []string{"EMail", "UserId", "Password", "PasswordSalt"}
-	tables["UserSession"] = []string{"SessionKey", "UserId", "LoginTime", "LastSeenTime"}
-	tables["UserContent"] = []string{"UserId", "FullName", "Phone", "EMail", "ProfileIcon", "ProfileHeader", "Description", "PDFs"}
-
-	//TODO: This could probably be made more effective if we scanned
-	//through UserContent first since that's where collisions are most likely to occur
-	for tableName, columnNames := range tables {
-		for i := 0; i < len(columnNames); i++ {
-			rows, err := dbi.DB.Query("SELECT * FROM "+tableName+" WHERE "+columnNames[i]+"=?", phrase)
-			if err != nil {
-				return false, err
-			}
-			for rows.Next() {
-				rows.Close()
-				return true, nil
-			}
-			rows.Close()
-		}
+//openSQL is the StorageOpener for every driver found in dialects
+func openSQL(cnf map[string]string) (Storage, error) {
+	dbi := new(DatabaseInterface)
+	dbi.setConfigurationMap(cnf)
+	err := dbi.OpenConnection()
+	if err != nil {
+		return nil, err
 	}
-	return false, nil
+	return dbi, nil
+}
+
+//IsFileReferenced returns true if any profile refers to the file at path,
+//path being relative to the www folder
+func (dbi *DatabaseInterface) IsFileReferenced(path string) (bool, error) {
+	rows, err := dbi.DB.Query("SELECT Id FROM FileReferences WHERE Path=? LIMIT 1", path)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), rows.Err()
 }
 
 //UniqueIdentifier queries the database for the given string in the
@@ -121,7 +195,7 @@ func (dbi *DatabaseInterface) UniqueIdentifier(identifier string) bool {
 //LookupUser sends a query to the database for the specified
 //username and password hash. Returns error if query failed
 func (dbi *DatabaseInterface) LookupUser(user *User) (*User, error) {
-	rows, err := dbi.DB.Query("SELECT * FROM Users WHERE EMail = ? OR UserId=?", user.Email, user.UserID)
+	rows, err := dbi.DB.Query("SELECT EMail, UserId, Password, PasswordSalt, EmailVerified FROM Users WHERE EMail = ? OR UserId=?", user.Email, user.UserID)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -132,7 +206,8 @@ func (dbi *DatabaseInterface) LookupUser(user *User) (*User, error) {
 			&user.Email,
 			&user.UserID,
 			&user.Password,
-			&user.Salt)
+			&user.Salt,
+			&user.EmailVerified)
 		if err != nil {
 			fmt.Println(err)
 		}
@@ -169,14 +244,15 @@ func (dbi *DatabaseInterface) GetUserIDFromPublicName(name string) (string, erro
 //returns error where err == nil if everything went okay
 func (dbi *DatabaseInterface) AddUser(user *User) error {
 	_, err := dbi.DB.Exec(
-		"INSERT INTO Users (EMail, UserId, Password, PasswordSalt) VALUES (?,?,?,?)",
+		"INSERT INTO Users (EMail, UserId, Password, PasswordSalt, EmailVerified) VALUES (?,?,?,?,?)",
 		user.Email,
 		user.UserID,
 		user.Password,
-		user.Salt)
+		user.Salt,
+		user.EmailVerified)
 
 	_, err = dbi.DB.Exec(
-		"INSERT INTO `UserContent` (`UserId`, `FullName`, `Phone`, `EMail`, `ProfileIcon`, `ProfileHeader`, `Description`, `PDFs`) VALUES (?, ?, ?, ?, ?, ?,? ,?)",
+		"INSERT INTO `UserContent` (`UserId`, `FullName`, `Phone`, `EMail`, `ProfileIcon`, `ProfileHeader`, `Description`, `PublicName`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
 		user.UserID,
 		"",
 		"",
@@ -211,14 +287,12 @@ func (dbi *DatabaseInterface) UpdatePublicName(uc *UserContents, user *User) err
 
 //GetUserContents looks up, and return, user content in database
 func (dbi *DatabaseInterface) GetUserContents(uid string, userContent *UserContents) (*UserContents, error) {
-	rows, err := dbi.DB.Query("SELECT * FROM UserContent WHERE UserId=?", uid)
+	rows, err := dbi.DB.Query("SELECT UserId, FullName, Phone, EMail, ProfileIcon, ProfileHeader, Description, PublicName FROM UserContent WHERE UserId=?", uid)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var jsonField []uint8
-
 	for rows.Next() {
 		err := rows.Scan(
 			&userContent.UserID,
@@ -228,41 +302,65 @@ func (dbi *DatabaseInterface) GetUserContents(uid string, userContent *UserConte
 			&userContent.ProfileIcon,
 			&userContent.ProfileHeader,
 			&userContent.Description,
-			&userContent.PublicName,
-			&jsonField)
+			&userContent.PublicName)
 		if err != nil {
 			fmt.Println(err)
 		}
-		//Because []string is not supported by the database api in go
-		userContent.PDFs = getStringArray(jsonField)
 	}
-	if contentInDatabase(userContent) {
-		return userContent, nil
+	if !contentInDatabase(userContent) {
+		return nil, ErrNoContentInDatabase
 	}
 
-	return nil, ErrNoContentInDatabase
+	userContent.PDFs, err = dbi.getUserPDFs(uid)
+	if err != nil {
+		return nil, err
+	}
+	return userContent, nil
+}
+
+//getUserPDFs reads the pdfs of the user in the order they are presented
+func (dbi *DatabaseInterface) getUserPDFs(uid string) ([]PDF, error) {
+	rows, err := dbi.DB.Query("SELECT Title, Path, Position, UploadTime, Size FROM UserPDFs WHERE UserId=? ORDER BY Position", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pdfs := []PDF{}
+	for rows.Next() {
+		var pdf PDF
+		err := rows.Scan(&pdf.Title, &pdf.Path, &pdf.Order, &pdf.UploadTime, &pdf.Size)
+		if err != nil {
+			return nil, err
+		}
+		pdfs = append(pdfs, pdf)
+	}
+	return pdfs, rows.Err()
 }
 
 //UpdateUserContent inserts the specified UserContent
 //for the specified UserId into the database
 func (dbi *DatabaseInterface) UpdateUserContent(uid string, uc *UserContents) error {
-	var buffer bytes.Buffer
-
 	invalidContent := validateUserContent(uc)
 	if invalidContent {
 		return errors.New("Invalid content")
 	}
-	buffer.WriteRune('[')
-	for i := 0; i < len(uc.PDFs); i++ {
-		buffer.WriteString(uc.PDFs[i].String())
 
-		if i < (len(uc.PDFs) - 1) { // don't add comma after last entry
-			buffer.WriteRune(',')
-		}
+	//Pdfs that are kept keep the time they were first added
+	previous, err := dbi.getUserPDFs(uid)
+	if err != nil {
+		return err
+	}
+	uploadTimes := make(map[string]time.Time)
+	for i := range previous {
+		uploadTimes[previous[i].Path] = previous[i].UploadTime
 	}
-	buffer.WriteRune(']')
 
-	_, err := dbi.DB.Exec("UPDATE UserContent set UserId=?, FullName=?, Phone=?, EMail=?, ProfileIcon=?, ProfileHeader=?, Description=?, PDFs=? WHERE UserId=?;",
+	tx, err := dbi.DB.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE UserContent set UserId=?, FullName=?, Phone=?, EMail=?, ProfileIcon=?, ProfileHeader=?, Description=? WHERE UserId=?;",
 		uid,
 		uc.FullName,
 		uc.Phone,
@@ -270,32 +368,87 @@ func (dbi *DatabaseInterface) UpdateUserContent(uid string, uc *UserContents) er
 		uc.ProfileIcon,
 		uc.ProfileHeader,
 		uc.Description,
-		buffer.String(),
 		uid)
-	return err
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM UserPDFs WHERE UserId=?", uid)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM FileReferences WHERE UserId=?", uid)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, reference := range fileReferences(uid, uc) {
+		_, err = tx.Exec("INSERT INTO FileReferences (Path, UserId, Kind) VALUES (?,?,?)", reference.Path, reference.UserID, reference.Kind)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for i := range uc.PDFs {
+		uploadTime, ok := uploadTimes[uc.PDFs[i].Path]
+		if !ok {
+			uploadTime = time.Now()
+		}
+		_, err = tx.Exec("INSERT INTO UserPDFs (UserId, Title, Path, Position, UploadTime, Size) VALUES (?,?,?,?,?,?)",
+			uid,
+			uc.PDFs[i].Title,
+			uc.PDFs[i].Path,
+			i,
+			uploadTime,
+			uc.PDFs[i].Size)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-//InsertUserSession creates a new row in the database for a user session
+//InsertUserSession creates a new row in the database for a user session.
+//The id of the new session is written to user.Session
 func (dbi *DatabaseInterface) InsertUserSession(user *User) error {
-	_, err := dbi.DB.Exec(
-		"INSERT INTO UserSession (SessionKey, UserId, LoginTime, LastSeenTime) VALUES (?,?,?,?)",
+	if user.Session == nil {
+		user.Session = new(UserSession)
+	}
+	now := time.Now()
+	result, err := dbi.DB.Exec(
+		"INSERT INTO UserSession (SessionKey, UserId, LoginTime, LastSeenTime, UserAgent, IP) VALUES (?,?,?,?,?,?)",
 		user.Token,
 		user.UserID,
-		time.Now().Format(time.RFC3339),
-		time.Now().Format(time.RFC3339))
+		now,
+		now,
+		user.Session.UserAgent,
+		user.Session.IP)
+	if err != nil {
+		return err
+	}
+	user.Session.ID, err = result.LastInsertId()
+	user.Session.SessionKey = user.Token
+	user.Session.LoginTime = now
+	user.Session.LastSeen = now
 	return err
 }
 
-//UpdateUserSession overwrites the current token value and last seen time in database
+//UpdateUserSession overwrites the token value and last seen time of
+//the session in user.Session, leaving other sessions of the user as is
 func (dbi *DatabaseInterface) UpdateUserSession(user *User) error {
-	_, err := dbi.DB.Exec("UPDATE UserSession set SessionKey=?, LastSeenTime=? WHERE UserId =?;", user.Token, time.Now().Format(time.RFC3339), user.UserID)
+	if user.Session == nil {
+		return ErrNoActiveSession
+	}
+	_, err := dbi.DB.Exec("UPDATE UserSession set SessionKey=?, LastSeenTime=? WHERE Id=? AND UserId=?;", user.Token, time.Now(), user.Session.ID, user.UserID)
 	return err
 }
 
 //GetUserSession reads the user session for the specified user
 //into the user session field of the struct
 func (dbi *DatabaseInterface) GetUserSession(user *User) (*User, error) {
-	rows, err := dbi.DB.Query("SELECT * FROM UserSession WHERE SessionKey=?", user.Token)
+	rows, err := dbi.DB.Query("SELECT Id, SessionKey, UserId, LoginTime, LastSeenTime, UserAgent, IP FROM UserSession WHERE SessionKey=?", user.Token)
 	if err != nil {
 		return nil, err
 	}
@@ -304,10 +457,13 @@ func (dbi *DatabaseInterface) GetUserSession(user *User) (*User, error) {
 	user.Session = new(UserSession)
 	for rows.Next() {
 		err := rows.Scan(
+			&user.Session.ID,
 			&user.Session.SessionKey,
 			&user.UserID,
 			&user.Session.LoginTime,
-			&user.Session.LastSeen)
+			&user.Session.LastSeen,
+			&user.Session.UserAgent,
+			&user.Session.IP)
 		if err != nil {
 			fmt.Println(err)
 		}
@@ -320,6 +476,32 @@ func (dbi *DatabaseInterface) GetUserSession(user *User) (*User, error) {
 	return nil, ErrNoActiveSession
 }
 
+//GetUserSessions returns every active session of the user
+func (dbi *DatabaseInterface) GetUserSessions(uid string) ([]*UserSession, error) {
+	rows, err := dbi.DB.Query("SELECT Id, SessionKey, LoginTime, LastSeenTime, UserAgent, IP FROM UserSession WHERE UserId=? ORDER BY LoginTime", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*UserSession{}
+	for rows.Next() {
+		session := new(UserSession)
+		err := rows.Scan(
+			&session.ID,
+			&session.SessionKey,
+			&session.LoginTime,
+			&session.LastSeen,
+			&session.UserAgent,
+			&session.IP)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
 //RemoveUserSession removes the session entry in database
 //that has the provided session key
 func (dbi *DatabaseInterface) RemoveUserSession(session *UserSession) error {
@@ -327,32 +509,603 @@ func (dbi *DatabaseInterface) RemoveUserSession(session *UserSession) error {
 	return err
 }
 
-//CleanUserSession gets the user sessions that has a larger last seen time
-//than 10 minutes
+//RemoveUserSessionByID removes a single session of the user.
+//Returns ErrNoActiveSession if the user has no session with that id
+func (dbi *DatabaseInterface) RemoveUserSessionByID(uid string, id int64) error {
+	result, err := dbi.DB.Exec("DELETE FROM UserSession WHERE Id=? AND UserId=?", id, uid)
+	if err != nil {
+		return err
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		return ErrNoActiveSession
+	}
+	return nil
+}
+
+//RemoveUserSessions removes every session of the user, logging them out everywhere
+func (dbi *DatabaseInterface) RemoveUserSessions(uid string) error {
+	_, err := dbi.DB.Exec("DELETE FROM UserSession WHERE UserId=?", uid)
+	return err
+}
+
+//CleanUserSession removes the user sessions that have not been seen for as
+//long as a refresh token lives, together with every expired token
 func (dbi *DatabaseInterface) CleanUserSession() error {
-	rows, err := dbi.DB.Query("SELECT * FROM UserSession WHERE LastSeenTime <= ?", (time.Now().Add(time.Minute * -10)).Format(time.RFC3339))
+	now := time.Now()
+	_, err := dbi.DB.Exec("DELETE FROM UserSession WHERE LastSeenTime <= ?", now.Add(-refreshTokenLifetime))
+	if err != nil {
+		return err
+	}
+	_, err = dbi.DB.Exec("DELETE FROM RefreshTokens WHERE ExpiresAt <= ?", now)
+	if err != nil {
+		return err
+	}
+	_, err = dbi.DB.Exec("DELETE FROM OneTimeTokens WHERE ExpiresAt <= ?", now)
+	return err
+}
+
+//InsertRefreshToken stores a newly issued refresh token
+func (dbi *DatabaseInterface) InsertRefreshToken(token *RefreshToken) error {
+	_, err := dbi.DB.Exec(
+		"INSERT INTO RefreshTokens (TokenHash, SessionId, UserId, Created, ExpiresAt) VALUES (?,?,?,?,?)",
+		token.Hash,
+		token.SessionID,
+		token.UserID,
+		token.Created,
+		token.ExpiresAt)
+	return err
+}
+
+//UseRefreshToken marks the refresh token with the hash as used and returns it.
+//Tokens that were already used are returned together with ErrRefreshTokenReused
+//so the caller knows which session to revoke
+func (dbi *DatabaseInterface) UseRefreshToken(hash string) (*RefreshToken, error) {
+	token := &RefreshToken{Hash: hash}
+	err := dbi.DB.QueryRow("SELECT SessionId, UserId, Created, ExpiresAt FROM RefreshTokens WHERE TokenHash=?", hash).Scan(
+		&token.SessionID,
+		&token.UserID,
+		&token.Created,
+		&token.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	//Only one of two concurrent requests with the same token gets to use it
+	result, err := dbi.DB.Exec("UPDATE RefreshTokens SET UsedAt=? WHERE TokenHash=? AND UsedAt IS NULL", time.Now(), hash)
+	if err != nil {
+		return nil, err
+	}
+	token.Used = true
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return token, ErrRefreshTokenReused
+	}
+	return token, nil
+}
+
+//InsertOneTimeToken stores a newly issued one time token
+func (dbi *DatabaseInterface) InsertOneTimeToken(token *OneTimeToken) error {
+	_, err := dbi.DB.Exec(
+		"INSERT INTO OneTimeTokens (TokenHash, UserId, Purpose, Created, ExpiresAt) VALUES (?,?,?,?,?)",
+		token.Hash,
+		token.UserID,
+		token.Purpose,
+		token.Created,
+		token.ExpiresAt)
+	return err
+}
+
+//UseOneTimeToken marks the token as used and returns it. Returns
+//ErrNoOneTimeToken if there is no unused token with the hash and purpose.
+//Expired tokens are returned as well, the caller decides what to do with them
+func (dbi *DatabaseInterface) UseOneTimeToken(hash, purpose string) (*OneTimeToken, error) {
+	result, err := dbi.DB.Exec("UPDATE OneTimeTokens SET UsedAt=? WHERE TokenHash=? AND Purpose=? AND UsedAt IS NULL", time.Now(), hash, purpose)
+	if err != nil {
+		return nil, err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return nil, ErrNoOneTimeToken
+	}
+
+	token := &OneTimeToken{Hash: hash, Purpose: purpose}
+	err = dbi.DB.QueryRow("SELECT UserId, Created, ExpiresAt FROM OneTimeTokens WHERE TokenHash=?", hash).Scan(
+		&token.UserID,
+		&token.Created,
+		&token.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+//RemoveOneTimeTokens removes the unused tokens issued to the user for the purpose
+func (dbi *DatabaseInterface) RemoveOneTimeTokens(uid, purpose string) error {
+	_, err := dbi.DB.Exec("DELETE FROM OneTimeTokens WHERE UserId=? AND Purpose=? AND UsedAt IS NULL", uid, purpose)
+	return err
+}
+
+//UpdatePassword overwrites the password hash and salt of the user
+func (dbi *DatabaseInterface) UpdatePassword(user *User) error {
+	result, err := dbi.DB.Exec("UPDATE Users SET Password=?, PasswordSalt=? WHERE UserId=?", user.Password, user.Salt, user.UserID)
+	if err != nil {
+		return err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrNoUserFound
+	}
+	return nil
+}
+
+//SetEmailVerified marks the email of the user as verified
+func (dbi *DatabaseInterface) SetEmailVerified(uid string) error {
+	result, err := dbi.DB.Exec("UPDATE Users SET EmailVerified=1 WHERE UserId=?", uid)
+	if err != nil {
+		return err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrNoUserFound
+	}
+	return nil
+}
+
+//GetTwoFactor reads the two-factor settings of the user
+func (dbi *DatabaseInterface) GetTwoFactor(uid string) (*TwoFactor, error) {
+	tf := &TwoFactor{UserID: uid}
+	err := dbi.DB.QueryRow("SELECT Secret, Enabled, LastStep, Created FROM TwoFactor WHERE UserId=?", uid).Scan(
+		&tf.Secret,
+		&tf.Enabled,
+		&tf.LastStep,
+		&tf.Created)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoTwoFactor
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+//SaveTwoFactor replaces the two-factor settings of the user
+func (dbi *DatabaseInterface) SaveTwoFactor(tf *TwoFactor) error {
+	tx, err := dbi.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM TwoFactor WHERE UserId=?", tf.UserID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO TwoFactor (UserId, Secret, Enabled, LastStep, Created) VALUES (?,?,?,?,?)",
+		tf.UserID,
+		tf.Secret,
+		tf.Enabled,
+		tf.LastStep,
+		time.Now())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+//RemoveTwoFactor turns two-factor authentication off for the user
+//and removes the recovery codes
+func (dbi *DatabaseInterface) RemoveTwoFactor(uid string) error {
+	tx, err := dbi.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM RecoveryCodes WHERE UserId=?", uid); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM TwoFactor WHERE UserId=?", uid); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+//UseTwoFactorStep records that a code of the time step has been accepted.
+//Returns ErrTwoFactorReplayed if the step, or a later one, was already used
+func (dbi *DatabaseInterface) UseTwoFactorStep(uid string, step int64) error {
+	result, err := dbi.DB.Exec("UPDATE TwoFactor SET LastStep=? WHERE UserId=? AND LastStep < ?", step, uid, step)
+	if err != nil {
+		return err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrTwoFactorReplayed
+	}
+	return nil
+}
+
+//SetRecoveryCodes replaces the recovery codes of the user with the hashes
+func (dbi *DatabaseInterface) SetRecoveryCodes(uid string, hashes []string) error {
+	tx, err := dbi.DB.Begin()
 	if err != nil {
 		return err
 	}
+	if _, err = tx.Exec("DELETE FROM RecoveryCodes WHERE UserId=?", uid); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err = tx.Exec("INSERT INTO RecoveryCodes (UserId, CodeHash) VALUES (?,?)", uid, hash); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+//UseRecoveryCode marks the recovery code with the hash as used.
+//Returns ErrNoRecoveryCode if the user has no such unused code
+func (dbi *DatabaseInterface) UseRecoveryCode(uid, hash string) error {
+	result, err := dbi.DB.Exec("UPDATE RecoveryCodes SET UsedAt=? WHERE UserId=? AND CodeHash=? AND UsedAt IS NULL", time.Now(), uid, hash)
+	if err != nil {
+		return err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrNoRecoveryCode
+	}
+	return nil
+}
+
+//GetExternalIdentity returns the link of the account at the identity provider
+func (dbi *DatabaseInterface) GetExternalIdentity(issuer, subject string) (*ExternalIdentity, error) {
+	identity := &ExternalIdentity{Issuer: issuer, Subject: subject}
+	err := dbi.DB.QueryRow("SELECT UserId, Email, Created FROM ExternalIdentities WHERE Issuer=? AND Subject=?", issuer, subject).Scan(
+		&identity.UserID,
+		&identity.Email,
+		&identity.Created)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoExternalIdentity
+	}
+	if err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+//AddExternalIdentity links an account at an identity provider to a user
+func (dbi *DatabaseInterface) AddExternalIdentity(identity *ExternalIdentity) error {
+	_, err := dbi.DB.Exec(
+		"INSERT INTO ExternalIdentities (Issuer, Subject, UserId, Email, Created) VALUES (?,?,?,?,?)",
+		identity.Issuer,
+		identity.Subject,
+		identity.UserID,
+		identity.Email,
+		time.Now())
+	return err
+}
+
+//InsertAPIKey stores a new api key and sets its id
+func (dbi *DatabaseInterface) InsertAPIKey(key *APIKey) error {
+	key.Created = time.Now()
+	result, err := dbi.DB.Exec(
+		"INSERT INTO ApiKeys (UserId, Name, Prefix, KeyHash, Scopes, Created) VALUES (?,?,?,?,?,?)",
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.Hash,
+		strings.Join(key.Scopes, " "),
+		key.Created)
+	if err != nil {
+		return err
+	}
+	key.ID, err = result.LastInsertId()
+	return err
+}
+
+//UseAPIKey returns the api key with the hash and records that it was used
+func (dbi *DatabaseInterface) UseAPIKey(hash string) (*APIKey, error) {
+	rows, err := dbi.DB.Query("SELECT Id, UserId, Name, Prefix, KeyHash, Scopes, Created, LastUsed FROM ApiKeys WHERE KeyHash=?", hash)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := scanAPIKeys(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoAPIKey
+	}
+	_, err = dbi.DB.Exec("UPDATE ApiKeys SET LastUsed=? WHERE Id=?", time.Now(), keys[0].ID)
+	return keys[0], err
+}
+
+//GetAPIKeys returns every api key of the user, oldest first
+func (dbi *DatabaseInterface) GetAPIKeys(uid string) ([]*APIKey, error) {
+	rows, err := dbi.DB.Query("SELECT Id, UserId, Name, Prefix, KeyHash, Scopes, Created, LastUsed FROM ApiKeys WHERE UserId=? ORDER BY Id", uid)
+	if err != nil {
+		return nil, err
+	}
+	return scanAPIKeys(rows)
+}
+
+//RemoveAPIKey revokes one api key of the user
+func (dbi *DatabaseInterface) RemoveAPIKey(uid string, id int64) error {
+	result, err := dbi.DB.Exec("DELETE FROM ApiKeys WHERE Id=? AND UserId=?", id, uid)
+	if err != nil {
+		return err
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		return ErrNoAPIKey
+	}
+	return nil
+}
+
+//scanAPIKeys reads and closes rows of the ApiKeys table
+func scanAPIKeys(rows *sql.Rows) ([]*APIKey, error) {
 	defer rows.Close()
 
-	userSession := new(UserSession)
+	keys := []*APIKey{}
 	for rows.Next() {
+		key := new(APIKey)
+		var scopes string
+		var lastUsed sql.NullTime
 		err := rows.Scan(
-			&userSession.SessionKey,
-			new(string),
-			&userSession.LoginTime,
-			&userSession.LastSeen)
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			&key.Hash,
+			&scopes,
+			&key.Created,
+			&lastUsed)
 		if err != nil {
-			fmt.Println(err)
-			return err
+			return nil, err
 		}
-		err = dbi.RemoveUserSession(userSession)
+		key.Scopes = strings.Fields(scopes)
+		key.LastUsed = lastUsed.Time
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+//InsertInviteCode stores a new invite code and sets its id
+func (dbi *DatabaseInterface) InsertInviteCode(code *InviteCode) error {
+	code.Created = time.Now()
+	var expires interface{}
+	if !code.ExpiresAt.IsZero() {
+		expires = code.ExpiresAt
+	}
+	result, err := dbi.DB.Exec(
+		"INSERT INTO InviteCodes (Prefix, CodeHash, MaxUses, Uses, Created, ExpiresAt) VALUES (?,?,?,0,?,?)",
+		code.Prefix,
+		code.Hash,
+		code.MaxUses,
+		code.Created,
+		expires)
+	if err != nil {
+		return err
+	}
+	code.ID, err = result.LastInsertId()
+	return err
+}
+
+//AddUserWithInvite inserts the user like AddUser while counting a use of
+//the invite code with the hash, if it has not expired or been used as many
+//times as allowed. Either both happen or neither does
+func (dbi *DatabaseInterface) AddUserWithInvite(user *User, hash string) error {
+	tx, err := dbi.DB.Begin()
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(
+		"UPDATE InviteCodes SET Uses=Uses+1 WHERE CodeHash=? AND Uses<MaxUses AND (ExpiresAt IS NULL OR ExpiresAt>?)",
+		hash,
+		time.Now())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if used, _ := result.RowsAffected(); used == 0 {
+		tx.Rollback()
+		return ErrNoInviteCode
+	}
+	_, err = tx.Exec(
+		"INSERT INTO Users (EMail, UserId, Password, PasswordSalt, EmailVerified) VALUES (?,?,?,?,?)",
+		user.Email,
+		user.UserID,
+		user.Password,
+		user.Salt,
+		user.EmailVerified)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO `UserContent` (`UserId`, `FullName`, `Phone`, `EMail`, `ProfileIcon`, `ProfileHeader`, `Description`, `PublicName`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		user.UserID,
+		"",
+		"",
+		"",
+		"",
+		"",
+		"",
+		"")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+//GetInviteCodes returns every invite code, oldest first
+func (dbi *DatabaseInterface) GetInviteCodes() ([]*InviteCode, error) {
+	rows, err := dbi.DB.Query("SELECT Id, Prefix, CodeHash, MaxUses, Uses, Created, ExpiresAt FROM InviteCodes ORDER BY Id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := []*InviteCode{}
+	for rows.Next() {
+		code := new(InviteCode)
+		var expires sql.NullTime
+		err = rows.Scan(
+			&code.ID,
+			&code.Prefix,
+			&code.Hash,
+			&code.MaxUses,
+			&code.Uses,
+			&code.Created,
+			&expires)
 		if err != nil {
-			fmt.Println(err)
-			return err
+			return nil, err
+		}
+		code.ExpiresAt = expires.Time
+		codes = append(codes, code)
+	}
+	return codes, rows.Err()
+}
+
+//RemoveInviteCode revokes an invite code
+func (dbi *DatabaseInterface) RemoveInviteCode(id int64) error {
+	result, err := dbi.DB.Exec("DELETE FROM InviteCodes WHERE Id=?", id)
+	if err != nil {
+		return err
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		return ErrNoInviteCode
+	}
+	return nil
+}
+
+//InsertUpload records a file stored by an upload, unless it would take the
+//files of the user over quota, which gives ErrQuotaExceeded. Touching the
+//row of the user first keeps parallel uploads of theirs from all passing the check
+func (dbi *DatabaseInterface) InsertUpload(upload *Upload, quota int64) error {
+	tx, err := dbi.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("UPDATE Users SET StorageQuota=StorageQuota WHERE UserId=?", upload.UserID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	var used int64
+	if err = tx.QueryRow("SELECT COALESCE(SUM(Size), 0) FROM Uploads WHERE UserId=?", upload.UserID).Scan(&used); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if used+upload.Size > quota {
+		tx.Rollback()
+		return ErrQuotaExceeded
+	}
+	upload.Created = time.Now()
+	_, err = tx.Exec(
+		"INSERT INTO Uploads (Path, UserId, Kind, Size, Hash, Created) VALUES (?,?,?,?,?,?)",
+		upload.Path,
+		upload.UserID,
+		upload.Kind,
+		upload.Size,
+		upload.Hash,
+		upload.Created)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+//GetUpload returns the upload of the file at path by the user,
+//path being relative to the www folder
+func (dbi *DatabaseInterface) GetUpload(path, uid string) (*Upload, error) {
+	upload := &Upload{Path: path, UserID: uid}
+	err := dbi.DB.QueryRow("SELECT Kind, Size, Hash, Created FROM Uploads WHERE Path=? AND UserId=?", path, uid).Scan(
+		&upload.Kind,
+		&upload.Size,
+		&upload.Hash,
+		&upload.Created)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoUpload
+	}
+	if err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+//CountUploads returns how many users have uploaded the file at path
+func (dbi *DatabaseInterface) CountUploads(path string) (int, error) {
+	var count int
+	err := dbi.DB.QueryRow("SELECT COUNT(*) FROM Uploads WHERE Path=?", path).Scan(&count)
+	return count, err
+}
+
+//GetUnreferencedUploads returns the uploads created before the time
+//that the profile of their user doesn't refer to
+func (dbi *DatabaseInterface) GetUnreferencedUploads(before time.Time) ([]*Upload, error) {
+	rows, err := dbi.DB.Query(
+		"SELECT Path, UserId, Kind, Size, Hash, Created FROM Uploads WHERE Created<? AND NOT EXISTS "+
+			"(SELECT Id FROM FileReferences WHERE FileReferences.Path=Uploads.Path AND FileReferences.UserId=Uploads.UserId)",
+		before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []*Upload{}
+	for rows.Next() {
+		upload := new(Upload)
+		err = rows.Scan(
+			&upload.Path,
+			&upload.UserID,
+			&upload.Kind,
+			&upload.Size,
+			&upload.Hash,
+			&upload.Created)
+		if err != nil {
+			return nil, err
 		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+//RemoveUpload forgets that the user uploaded the file at path
+func (dbi *DatabaseInterface) RemoveUpload(path, uid string) error {
+	_, err := dbi.DB.Exec("DELETE FROM Uploads WHERE Path=? AND UserId=?", path, uid)
+	return err
+}
+
+//GetStorageUsed returns the total size of the files the user has uploaded
+func (dbi *DatabaseInterface) GetStorageUsed(uid string) (int64, error) {
+	var used int64
+	err := dbi.DB.QueryRow("SELECT COALESCE(SUM(Size), 0) FROM Uploads WHERE UserId=?", uid).Scan(&used)
+	return used, err
+}
+
+//GetStorageQuota returns the quota the user has been given, or
+//ErrNoStorageQuota if the default applies
+func (dbi *DatabaseInterface) GetStorageQuota(uid string) (int64, error) {
+	var quota sql.NullInt64
+	err := dbi.DB.QueryRow("SELECT StorageQuota FROM Users WHERE UserId=?", uid).Scan(&quota)
+	if err == sql.ErrNoRows {
+		return 0, ErrNoUserFound
+	}
+	if err != nil {
+		return 0, err
 	}
+	if !quota.Valid {
+		return 0, ErrNoStorageQuota
+	}
+	return quota.Int64, nil
+}
+
+//SetStorageQuota gives the user a quota of their own,
+//a negative quota returns them to the default
+func (dbi *DatabaseInterface) SetStorageQuota(uid string, quota int64) error {
+	var value interface{}
+	if quota >= 0 {
+		value = quota
+	}
+	_, err := dbi.DB.Exec("UPDATE Users SET StorageQuota=? WHERE UserId=?", value, uid)
 	return err
 }
 
@@ -363,7 +1116,7 @@ func (dbi *DatabaseInterface) CloseConnection() {
 
 //getConnectionString returns the connection details as a formatted dataSourceName
 func (dbi *DatabaseInterface) getConnectionString() string {
-	return dbi.User + ":" + dbi.Password + "@" + dbi.DataSourceName
+	return dbi.dialect.connectionString(dbi)
 }
 
 //inDatabase checks if the current user was found in the database
@@ -377,15 +1130,13 @@ func contentInDatabase(u *UserContents) bool {
 	return u.EMail != ""
 }
 
-//In order to handle strange behaviour in sql
-func getStringArray(arr []uint8) []PDF {
-	str := string(arr)
-	var pdfs []PDF
-	json.Unmarshal([]byte(str), &pdfs)
-	return pdfs
-}
-
+//validateUserContent returns true if any field is too long for the database
 func validateUserContent(uc *UserContents) bool {
+	for i := range uc.PDFs {
+		if len(uc.PDFs[i].Title) >= 255 || len(uc.PDFs[i].Path) >= 255 {
+			return true
+		}
+	}
 	return !(len(uc.FullName) < 70 &&
 		len(uc.Phone) < 50 &&
 		len(uc.EMail) < 80 &&
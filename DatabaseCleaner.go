@@ -2,13 +2,13 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
 	"time"
 )
 
 //SessionCleaner wakes up every ten minutes and
 //removes inactive sessions from database
+//and forgets old failed logins and rate limits
 func SessionCleaner(quit chan bool) {
 	for {
 		select {
@@ -16,13 +16,17 @@ func SessionCleaner(quit chan bool) {
 			return
 		default:
 			time.Sleep(time.Minute * 10)
-			db.CleanUserSession()
+			if db != nil {
+				db.CleanUserSession()
+			}
+			loginGuard.Prune()
+			pruneRateLimiters()
 		}
 	}
 }
 
-//ImageCleaner wakes up every 24h and
-//removes unused images from the server
+//ImageCleaner wakes up every 24h and removes unused uploads from
+//the server, as well as unused images from before uploads were recorded
 func ImageCleaner(quit chan bool) {
 	for {
 		select {
@@ -30,37 +34,37 @@ func ImageCleaner(quit chan bool) {
 			return
 		default:
 			time.Sleep(time.Hour * 24)
-			profileHeaders, err := filepath.Glob("www/img/profile-headers/*")
+			if db == nil {
+				continue
+			}
+			cleanUploads(time.Now().Add(-time.Hour * 24))
+			profileHeaders, err := blobs.List("img/profile-headers/")
 			if err != nil {
 				fmt.Println(err)
 				return
 			}
 
-			profileIcons, err := filepath.Glob("www/img/profile-icons/*")
+			profileIcons, err := blobs.List("img/profile-icons/")
 			if err != nil {
 				fmt.Println(err)
 				return
 			}
 
-			for i := 0; i < len(profileHeaders); i++ {
-				if isImg(profileHeaders[i]) {
-					inDB, err := db.UniversalLookup(profileHeaders[i])
+			images := append(profileHeaders, profileIcons...)
+			for i := 0; i < len(images); i++ {
+				if isImg(images[i]) {
+					referenced, err := db.IsFileReferenced(images[i])
 					if err != nil {
-						return
-					}
-					if !inDB {
-						os.Remove(profileHeaders[i])
+						fmt.Println(err)
+						break
 					}
-				}
-			}
-			for i := 0; i < len(profileIcons); i++ {
-				if isImg(profileIcons[i]) {
-					inDB, err := db.UniversalLookup(profileIcons[i])
+					uploads, err := db.CountUploads(images[i])
 					if err != nil {
-						return
+						fmt.Println(err)
+						break
 					}
-					if !inDB {
-						os.Remove(profileIcons[i])
+					if !referenced && uploads == 0 {
+						blobs.Remove(images[i])
 					}
 				}
 			}
@@ -68,7 +72,26 @@ func ImageCleaner(quit chan bool) {
 	}
 }
 
+//cleanUploads forgets uploads made before the time that the profile of their
+//user doesn't refer to, and removes the files nobody has uploaded anymore
+func cleanUploads(before time.Time) {
+	uploads, err := db.GetUnreferencedUploads(before)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, upload := range uploads {
+		if err = db.RemoveUpload(upload.Path, upload.UserID); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if err = releaseBlob(upload.Path); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
 func isImg(fileName string) bool {
-	extension := fileName[len(fileName)-4 : len(fileName)]
-	return extension == ".jpg" || extension == ".png" || extension == ".gif"
+	extension := filepath.Ext(fileName)
+	return extension == ".jpg" || extension == ".png" || extension == ".gif" || extension == ".webp"
 }
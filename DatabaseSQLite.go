@@ -0,0 +1,44 @@
+package main
+
+import (
+	"database/sql"
+	"regexp"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+//The sqlite dialect lets the server run on a single database file, which
+//suits development machines and small boxes without a MySQL server
+func init() {
+	sql.Register("sqlite3_mango", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			//Foreign keys are off by default in SQLite
+			if _, err := conn.Exec("PRAGMA foreign_keys = ON", nil); err != nil {
+				return err
+			}
+			//SQLite has no REGEXP implementation of its own
+			return conn.RegisterFunc("regexp", sqliteRegexp, true)
+		},
+	})
+
+	sqlite := &sqlDialect{
+		driver:     "sqlite3_mango",
+		migrations: "sqlite",
+		//Every connection to an in memory database is a database of its own,
+		//one connection also keeps writers from locking each other out
+		maxOpenConns: 1,
+		connectionString: func(dbi *DatabaseInterface) string {
+			return dbi.DataSourceName
+		},
+	}
+	dialects["sqlite3"] = sqlite
+	dialects["sqlite"] = sqlite
+	RegisterStorage("sqlite3", openSQL)
+	RegisterStorage("sqlite", openSQL)
+}
+
+//sqliteRegexp implements `value REGEXP pattern` case insensitively
+//to match the behaviour of the utf8_unicode_ci collation used in MySQL
+func sqliteRegexp(pattern, value string) (bool, error) {
+	return regexp.MatchString("(?i)"+pattern, value)
+}
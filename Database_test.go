@@ -0,0 +1,480 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//openTestSQLite returns a Storage backed by a fresh in memory sqlite database
+func openTestSQLite(t *testing.T) Storage {
+	store, err := OpenStorage(map[string]string{"DRIVERNAME": "sqlite3", "DATASOURCENAME": ":memory:", "AUTOMIGRATE": "true"})
+	if err != nil {
+		t.Fatalf("Unable to open sqlite: %v", err)
+	}
+	return store
+}
+
+func TestSQLiteUserRoundTrip(t *testing.T) {
+	store := openTestSQLite(t)
+	defer store.CloseConnection()
+
+	err := store.AddUser(&User{Email: "alice@example.com", UserID: "uid", Password: "hash", Salt: "salt"})
+	if err != nil {
+		t.Fatalf("Unable to add user: %v", err)
+	}
+	user, err := store.LookupUser(&User{Email: "ALICE@example.com"})
+	if err != nil {
+		t.Fatalf("Email lookup should be case insensitive: %v", err)
+	}
+	if user.UserID != "uid" || user.Salt != "salt" {
+		t.Fatalf("Wrong user returned: %+v", user)
+	}
+	if store.UniqueIdentifier("uid") {
+		t.Fatalf("UserId should not be unique after insert")
+	}
+	if user.EmailVerified {
+		t.Fatalf("New users should not be verified")
+	}
+	store.SetEmailVerified("uid")
+	if user, _ = store.LookupUser(&User{UserID: "uid"}); !user.EmailVerified {
+		t.Fatalf("Email should be verified")
+	}
+}
+
+func TestSQLiteUserContent(t *testing.T) {
+	store := openTestSQLite(t)
+	defer store.CloseConnection()
+
+	user := &User{Email: "bob@example.com", UserID: "bob", Password: "hash", Salt: "salt"}
+	store.AddUser(user)
+	content := &UserContents{FullName: "Bob", EMail: user.Email, PDFs: []PDF{{Title: "CV", Path: "pdf/cv.pdf"}}}
+	if err := store.UpdateUserContent(user.UserID, content); err != nil {
+		t.Fatalf("Unable to update content: %v", err)
+	}
+	content.PublicName = "bob"
+	store.UpdatePublicName(content, user)
+
+	read, err := store.GetUserContents(user.UserID, new(UserContents))
+	if err != nil {
+		t.Fatalf("Unable to read content: %v", err)
+	}
+	if len(read.PDFs) != 1 || read.PDFs[0].Path != "pdf/cv.pdf" {
+		t.Fatalf("PDFs were not stored: %+v", read.PDFs)
+	}
+	if found, _ := store.LookupPublicName("BO"); !found {
+		t.Fatalf("Public name should match substrings")
+	}
+	if uid, _ := store.GetUserIDFromPublicName("bob"); uid != "bob" {
+		t.Fatalf("Expected uid bob, got %q", uid)
+	}
+}
+
+func TestSQLiteSessions(t *testing.T) {
+	store := openTestSQLite(t)
+	defer store.CloseConnection()
+
+	store.AddUser(&User{Email: "carol@example.com", UserID: "carol", Password: "hash", Salt: "salt"})
+	user := &User{UserID: "carol", Token: "token"}
+	if err := store.InsertUserSession(user); err != nil {
+		t.Fatalf("Unable to insert session: %v", err)
+	}
+	found, err := store.GetUserSession(&User{Token: "token"})
+	if err != nil || found.UserID != "carol" {
+		t.Fatalf("Session was not found: %v", err)
+	}
+	if err = store.RemoveUserSession(found.Session); err != nil {
+		t.Fatalf("Unable to remove session: %v", err)
+	}
+	if _, err = store.GetUserSession(&User{Token: "token"}); err != ErrNoActiveSession {
+		t.Fatalf("Session should have been removed")
+	}
+}
+
+func TestSQLiteConcurrentSessions(t *testing.T) {
+	store := openTestSQLite(t)
+	defer store.CloseConnection()
+
+	store.AddUser(&User{Email: "erin@example.com", UserID: "erin", Password: "hash", Salt: "salt"})
+	laptop := &User{UserID: "erin", Token: "laptop", Session: &UserSession{UserAgent: "Firefox", IP: "10.0.0.1"}}
+	phone := &User{UserID: "erin", Token: "phone", Session: &UserSession{UserAgent: "Safari", IP: "10.0.0.2"}}
+	store.InsertUserSession(laptop)
+	store.InsertUserSession(phone)
+	if laptop.Session.ID == phone.Session.ID {
+		t.Fatalf("Every login should get its own session id")
+	}
+
+	laptop.Token = "laptop-refreshed"
+	if err := store.UpdateUserSession(laptop); err != nil {
+		t.Fatalf("Unable to refresh session: %v", err)
+	}
+	if _, err := store.GetUserSession(&User{Token: "phone"}); err != nil {
+		t.Fatalf("Refreshing one session should not affect the other")
+	}
+
+	sessions, _ := store.GetUserSessions("erin")
+	if len(sessions) != 2 || sessions[1].UserAgent != "Safari" {
+		t.Fatalf("Expected both sessions, got %+v", sessions)
+	}
+	if err := store.RemoveUserSessionByID("someone-else", phone.Session.ID); err != ErrNoActiveSession {
+		t.Fatalf("Sessions of other users should not be removable")
+	}
+	if err := store.RemoveUserSessionByID("erin", phone.Session.ID); err != nil {
+		t.Fatalf("Unable to revoke session: %v", err)
+	}
+	if _, err := store.GetUserSession(&User{Token: "laptop-refreshed"}); err != nil {
+		t.Fatalf("Revoking one session should keep the other")
+	}
+}
+
+func TestSQLiteRefreshTokens(t *testing.T) {
+	store := openTestSQLite(t)
+	defer store.CloseConnection()
+
+	store.AddUser(&User{Email: "gina@example.com", UserID: "gina", Password: "hash", Salt: "salt"})
+	user := &User{UserID: "gina", Token: "token"}
+	store.InsertUserSession(user)
+	now := time.Now()
+	token := &RefreshToken{Hash: hashToken("refresh"), SessionID: user.Session.ID, UserID: "gina", Created: now, ExpiresAt: now.Add(time.Hour)}
+	if err := store.InsertRefreshToken(token); err != nil {
+		t.Fatalf("Unable to insert refresh token: %v", err)
+	}
+
+	used, err := store.UseRefreshToken(token.Hash)
+	if err != nil || used.SessionID != user.Session.ID {
+		t.Fatalf("Unable to use refresh token: %v", err)
+	}
+	if _, err = store.UseRefreshToken(token.Hash); err != ErrRefreshTokenReused {
+		t.Fatalf("Second use should be detected, got %v", err)
+	}
+
+	store.RemoveUserSession(user.Session)
+	if _, err = store.UseRefreshToken(token.Hash); err != ErrNoRefreshToken {
+		t.Fatalf("Removing the session should remove its refresh tokens, got %v", err)
+	}
+}
+
+func TestSQLiteOneTimeTokens(t *testing.T) {
+	store := openTestSQLite(t)
+	defer store.CloseConnection()
+
+	store.AddUser(&User{Email: "ivan@example.com", UserID: "ivan", Password: "hash", Salt: "salt"})
+	now := time.Now()
+	token := &OneTimeToken{Hash: hashToken("reset"), UserID: "ivan", Purpose: TokenPurposePasswordReset, Created: now, ExpiresAt: now.Add(time.Hour)}
+	if err := store.InsertOneTimeToken(token); err != nil {
+		t.Fatalf("Unable to insert token: %v", err)
+	}
+	if _, err := store.UseOneTimeToken(token.Hash, "something-else"); err != ErrNoOneTimeToken {
+		t.Fatalf("Tokens should only be usable for their purpose, got %v", err)
+	}
+	used, err := store.UseOneTimeToken(token.Hash, TokenPurposePasswordReset)
+	if err != nil || used.UserID != "ivan" {
+		t.Fatalf("Unable to use token: %v", err)
+	}
+	if _, err = store.UseOneTimeToken(token.Hash, TokenPurposePasswordReset); err != ErrNoOneTimeToken {
+		t.Fatalf("Tokens should only be usable once, got %v", err)
+	}
+	other := &OneTimeToken{Hash: hashToken("other"), UserID: "ivan", Purpose: TokenPurposePasswordReset, Created: now, ExpiresAt: now.Add(time.Hour)}
+	store.InsertOneTimeToken(other)
+	if err = store.RemoveOneTimeTokens("ivan", TokenPurposePasswordReset); err != nil {
+		t.Fatalf("Unable to remove tokens: %v", err)
+	}
+	if _, err = store.UseOneTimeToken(other.Hash, TokenPurposePasswordReset); err != ErrNoOneTimeToken {
+		t.Fatalf("Removed tokens should not be usable, got %v", err)
+	}
+
+	if err = store.UpdatePassword(&User{UserID: "ivan", Password: "new-hash", Salt: "new-salt"}); err != nil {
+		t.Fatalf("Unable to update password: %v", err)
+	}
+	user, _ := store.LookupUser(&User{UserID: "ivan"})
+	if user.Password != "new-hash" || user.Salt != "new-salt" {
+		t.Fatalf("Password was not updated: %+v", user)
+	}
+}
+
+func TestSQLiteTwoFactor(t *testing.T) {
+	store := openTestSQLite(t)
+	defer store.CloseConnection()
+
+	store.AddUser(&User{Email: "lisa@example.com", UserID: "lisa", Password: "hash", Salt: "salt"})
+	if _, err := store.GetTwoFactor("lisa"); err != ErrNoTwoFactor {
+		t.Fatalf("Expected ErrNoTwoFactor, got %v", err)
+	}
+	store.SaveTwoFactor(&TwoFactor{UserID: "lisa", Secret: "SECRET", Enabled: true, LastStep: 10})
+	if err := store.UseTwoFactorStep("lisa", 10); err != ErrTwoFactorReplayed {
+		t.Fatalf("Used steps should be refused, got %v", err)
+	}
+	if err := store.UseTwoFactorStep("lisa", 11); err != nil {
+		t.Fatalf("Later steps should be accepted: %v", err)
+	}
+	if tf, _ := store.GetTwoFactor("lisa"); !tf.Enabled || tf.LastStep != 11 {
+		t.Fatalf("Two-factor settings were not stored: %+v", tf)
+	}
+
+	store.SetRecoveryCodes("lisa", []string{"a", "b"})
+	if err := store.UseRecoveryCode("lisa", "a"); err != nil {
+		t.Fatalf("Unable to use recovery code: %v", err)
+	}
+	if err := store.UseRecoveryCode("lisa", "a"); err != ErrNoRecoveryCode {
+		t.Fatalf("Recovery codes should only work once, got %v", err)
+	}
+	store.RemoveTwoFactor("lisa")
+	if err := store.UseRecoveryCode("lisa", "b"); err != ErrNoRecoveryCode {
+		t.Fatalf("Recovery codes should be removed with two-factor, got %v", err)
+	}
+}
+
+func TestSQLiteExternalIdentities(t *testing.T) {
+	store := openTestSQLite(t)
+	defer store.CloseConnection()
+
+	store.AddUser(&User{Email: "mia@example.com", UserID: "mia", Password: "hash", Salt: "salt"})
+	if _, err := store.GetExternalIdentity("https://id.example.com", "Mia"); err != ErrNoExternalIdentity {
+		t.Fatalf("Expected ErrNoExternalIdentity, got %v", err)
+	}
+	err := store.AddExternalIdentity(&ExternalIdentity{Issuer: "https://id.example.com", Subject: "Mia", UserID: "mia", Email: "mia@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity, err := store.GetExternalIdentity("https://id.example.com", "Mia"); err != nil || identity.UserID != "mia" {
+		t.Fatalf("Identity was not stored: %v", err)
+	}
+	if err = store.AddExternalIdentity(&ExternalIdentity{Issuer: "https://id.example.com", Subject: "Mia", UserID: "mia"}); err == nil {
+		t.Fatalf("Subjects should only be linked once")
+	}
+}
+
+func TestSQLiteAPIKeys(t *testing.T) {
+	store := openTestSQLite(t)
+	defer store.CloseConnection()
+
+	store.AddUser(&User{Email: "olga@example.com", UserID: "olga", Password: "hash", Salt: "salt"})
+	key := &APIKey{UserID: "olga", Name: "ci", Prefix: "mango_abcdef", Hash: hashToken("key"), Scopes: []string{ScopeUploadPDF, ScopeProfileWrite}}
+	if err := store.InsertAPIKey(key); err != nil || key.ID == 0 {
+		t.Fatalf("Unable to insert api key: %v", err)
+	}
+	used, err := store.UseAPIKey(hashToken("key"))
+	if err != nil || used.UserID != "olga" || len(used.Scopes) != 2 || !used.LastUsed.IsZero() {
+		t.Fatalf("Unexpected key %+v (%v)", used, err)
+	}
+	keys, _ := store.GetAPIKeys("olga")
+	if len(keys) != 1 || keys[0].LastUsed.IsZero() {
+		t.Fatalf("Use should be recorded: %+v", keys)
+	}
+	if err = store.RemoveAPIKey("someone else", key.ID); err != ErrNoAPIKey {
+		t.Fatalf("Keys of other users should not be removed, got %v", err)
+	}
+	store.RemoveAPIKey("olga", key.ID)
+	if _, err = store.UseAPIKey(hashToken("key")); err != ErrNoAPIKey {
+		t.Fatalf("Expected ErrNoAPIKey, got %v", err)
+	}
+}
+
+func TestSQLiteInviteCodes(t *testing.T) {
+	store := openTestSQLite(t)
+	defer store.CloseConnection()
+
+	code := &InviteCode{Prefix: "abcd", Hash: hashToken("code"), MaxUses: 2}
+	expired := &InviteCode{Prefix: "efgh", Hash: hashToken("old"), MaxUses: 2, ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := store.InsertInviteCode(code); err != nil || code.ID == 0 {
+		t.Fatalf("Unable to insert invite code: %v", err)
+	}
+	store.InsertInviteCode(expired)
+	invited := func(name string) *User {
+		return &User{Email: name + "@example.com", UserID: name, Password: "hash", Salt: "salt-" + name}
+	}
+	if err := store.AddUserWithInvite(invited("old"), hashToken("old")); err != ErrNoInviteCode {
+		t.Fatalf("Expired codes should not be usable, got %v", err)
+	}
+	if _, err := store.LookupUser(&User{Email: "old@example.com"}); err != ErrNoUserFound {
+		t.Fatalf("User should not be added without a usable code, got %v", err)
+	}
+	if err := store.AddUserWithInvite(invited("eva"), hashToken("code")); err != nil {
+		t.Fatalf("First use should be allowed: %v", err)
+	}
+	//A registration that fails must not use up the code
+	if err := store.AddUserWithInvite(invited("eva"), hashToken("code")); err == nil || err == ErrNoInviteCode {
+		t.Fatalf("Adding a user twice should fail by itself, got %v", err)
+	}
+	if err := store.AddUserWithInvite(invited("finn"), hashToken("code")); err != nil {
+		t.Fatalf("Second use should be allowed: %v", err)
+	}
+	if err := store.AddUserWithInvite(invited("gus"), hashToken("code")); err != ErrNoInviteCode {
+		t.Fatalf("Codes should not be usable more than allowed, got %v", err)
+	}
+	codes, _ := store.GetInviteCodes()
+	if len(codes) != 2 || codes[0].Uses != 2 || !codes[0].ExpiresAt.IsZero() || codes[1].ExpiresAt.IsZero() {
+		t.Fatalf("Unexpected invite codes %+v", codes)
+	}
+	store.RemoveInviteCode(code.ID)
+	if err := store.RemoveInviteCode(code.ID); err != ErrNoInviteCode {
+		t.Fatalf("Expected ErrNoInviteCode, got %v", err)
+	}
+}
+
+func TestSQLiteUploadsAndQuotas(t *testing.T) {
+	store := openTestSQLite(t)
+	defer store.CloseConnection()
+
+	store.AddUser(&User{Email: "pia@example.com", UserID: "pia", Password: "hash", Salt: "salt"})
+	err := store.InsertUpload(&Upload{Path: "pdf/cv.pdf", UserID: "pia", Kind: FileKindPDF, Size: 42, Hash: hashToken("cv")}, 100)
+	if err != nil {
+		t.Fatalf("Unable to record upload: %v", err)
+	}
+	if err = store.InsertUpload(&Upload{Path: "pdf/cv.pdf", UserID: "pia", Kind: FileKindPDF}, 100); err == nil {
+		t.Fatalf("A user can only upload a path once")
+	}
+	if err = store.InsertUpload(&Upload{Path: "pdf/letter.pdf", UserID: "pia", Kind: FileKindPDF, Size: 59}, 100); err != ErrQuotaExceeded {
+		t.Fatalf("Uploads over quota should not be recorded, got %v", err)
+	}
+	store.AddUser(&User{Email: "rob@example.com", UserID: "rob", Password: "hash", Salt: "salt2"})
+	if err = store.InsertUpload(&Upload{Path: "pdf/cv.pdf", UserID: "rob", Kind: FileKindPDF, Size: 42}, 100); err != nil {
+		t.Fatalf("Users should be able to share a path: %v", err)
+	}
+	if count, _ := store.CountUploads("pdf/cv.pdf"); count != 2 {
+		t.Fatalf("Expected two uploads, got %d", count)
+	}
+	store.UpdateUserContent("rob", &UserContents{EMail: "rob@example.com", PDFs: []PDF{{Title: "CV", Path: "pdf/cv.pdf"}}})
+	unreferenced, err := store.GetUnreferencedUploads(time.Now().Add(time.Minute))
+	if err != nil || len(unreferenced) != 1 || unreferenced[0].UserID != "pia" {
+		t.Fatalf("Only the upload of pia is unreferenced: %+v (%v)", unreferenced, err)
+	}
+	if unreferenced, _ = store.GetUnreferencedUploads(time.Now().Add(-time.Minute)); len(unreferenced) != 0 {
+		t.Fatalf("Recent uploads should not be returned: %+v", unreferenced)
+	}
+	upload, err := store.GetUpload("pdf/cv.pdf", "pia")
+	if err != nil || upload.UserID != "pia" || upload.Size != 42 || upload.Hash != hashToken("cv") {
+		t.Fatalf("Unexpected upload %+v (%v)", upload, err)
+	}
+	if used, _ := store.GetStorageUsed("pia"); used != 42 {
+		t.Fatalf("Expected 42 bytes used, got %d", used)
+	}
+	if _, err = store.GetStorageQuota("pia"); err != ErrNoStorageQuota {
+		t.Fatalf("New users should have the default quota, got %v", err)
+	}
+	store.SetStorageQuota("pia", 1<<20)
+	if quota, _ := store.GetStorageQuota("pia"); quota != 1<<20 {
+		t.Fatalf("Expected a quota of 1MB, got %d", quota)
+	}
+	store.SetStorageQuota("pia", -1)
+	if _, err = store.GetStorageQuota("pia"); err != ErrNoStorageQuota {
+		t.Fatalf("Quota should be back to the default, got %v", err)
+	}
+
+	store.RemoveUpload("pdf/cv.pdf", "pia")
+	if _, err = store.GetUpload("pdf/cv.pdf", "pia"); err != ErrNoUpload {
+		t.Fatalf("Expected ErrNoUpload, got %v", err)
+	}
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	store := openTestSQLite(t)
+	defer store.CloseConnection()
+	migrator := store.(Migrator)
+
+	version, err := migrator.SchemaVersion()
+	if err != nil || version != migrator.LatestSchemaVersion() {
+		t.Fatalf("Expected schema at latest version, got %d (%v)", version, err)
+	}
+	if err = migrator.Migrate(0); err != nil {
+		t.Fatalf("Unable to revert every migration: %v", err)
+	}
+	if err = migrator.Migrate(migrator.LatestSchemaVersion()); err != nil {
+		t.Fatalf("Unable to migrate back up: %v", err)
+	}
+	if err = migrator.Migrate(migrator.LatestSchemaVersion() + 1); err != ErrUnknownMigration {
+		t.Fatalf("Migrating past the latest version should fail, got %v", err)
+	}
+}
+
+func TestSchemaAheadRefused(t *testing.T) {
+	cnf := map[string]string{"DRIVERNAME": "sqlite3", "DATASOURCENAME": filepath.Join(t.TempDir(), "mango.db"), "AUTOMIGRATE": "true"}
+	store, err := OpenStorage(cnf)
+	if err != nil {
+		t.Fatalf("Unable to open sqlite: %v", err)
+	}
+	dbi := store.(*DatabaseInterface)
+	dbi.DB.Exec("INSERT INTO schema_version (version) VALUES (?)", dbi.LatestSchemaVersion()+1)
+	store.CloseConnection()
+
+	if _, err = OpenStorage(cnf); err != ErrSchemaAhead {
+		t.Fatalf("Expected ErrSchemaAhead, got %v", err)
+	}
+}
+
+func TestSchemaBehindRefused(t *testing.T) {
+	cnf := map[string]string{"DRIVERNAME": "sqlite3", "DATASOURCENAME": filepath.Join(t.TempDir(), "mango.db")}
+	if _, err := OpenStorage(cnf); err != ErrSchemaBehind {
+		t.Fatalf("A new database should not be migrated unless asked to, got %v", err)
+	}
+	cnf["AUTOMIGRATE"] = "true"
+	store, err := OpenStorage(cnf)
+	if err != nil {
+		t.Fatalf("Unable to migrate when asked to: %v", err)
+	}
+	store.(Migrator).Migrate(2)
+	store.CloseConnection()
+
+	delete(cnf, "AUTOMIGRATE")
+	if _, err = OpenStorage(cnf); err != ErrSchemaBehind {
+		t.Fatalf("Expected ErrSchemaBehind, got %v", err)
+	}
+}
+
+func TestSQLitePDFMigration(t *testing.T) {
+	store := openTestSQLite(t)
+	defer store.CloseConnection()
+	dbi := store.(*DatabaseInterface)
+
+	if err := dbi.Migrate(2); err != nil {
+		t.Fatalf("Unable to migrate down: %v", err)
+	}
+	dbi.DB.Exec("INSERT INTO Users (EMail, UserId, Password, PasswordSalt) VALUES (?,?,?,?)", "old@example.com", "old", "hash", "salt")
+	dbi.DB.Exec("INSERT INTO UserContent (UserId, FullName, Phone, EMail, ProfileIcon, ProfileHeader, Description, PublicName, PDFs) VALUES (?,?,?,?,?,?,?,?,?)",
+		"old", "Old", "", "old@example.com", "", "", "", "old", `[{"Title":"CV","Path":"pdf/cv.pdf"},{"Title":"Grades","Path":"pdf/grades.pdf"}]`)
+	//Long portfolios were cut off by the size of the column
+	dbi.DB.Exec("INSERT INTO Users (EMail, UserId, Password, PasswordSalt) VALUES (?,?,?,?)", "cut@example.com", "cut", "hash", "salt2")
+	dbi.DB.Exec("INSERT INTO UserContent (UserId, FullName, Phone, EMail, ProfileIcon, ProfileHeader, Description, PublicName, PDFs) VALUES (?,?,?,?,?,?,?,?,?)",
+		"cut", "Cut", "", "cut@example.com", "", "", "", "cut", `[{"Title":"CV","Path":"pdf/cv.pdf"},{"Tit`)
+	if err := dbi.Migrate(dbi.LatestSchemaVersion()); err != nil {
+		t.Fatalf("Unable to migrate up: %v", err)
+	}
+
+	content, err := store.GetUserContents("old", new(UserContents))
+	if err != nil {
+		t.Fatalf("Unable to read content: %v", err)
+	}
+	if len(content.PDFs) != 2 || content.PDFs[1].Path != "pdf/grades.pdf" || content.PDFs[1].Order != 1 {
+		t.Fatalf("PDFs were not converted: %+v", content.PDFs)
+	}
+	if content, err = store.GetUserContents("cut", new(UserContents)); err != nil || len(content.PDFs) != 1 || content.PDFs[0].Path != "pdf/cv.pdf" {
+		t.Fatalf("Complete PDFs of a cut off column should be kept: %+v (%v)", content, err)
+	}
+
+	if err = dbi.Migrate(2); err != nil {
+		t.Fatalf("Unable to revert the conversion: %v", err)
+	}
+	var jsonField string
+	dbi.DB.QueryRow("SELECT PDFs FROM UserContent WHERE UserId=?", "old").Scan(&jsonField)
+	if jsonField != `[{"Title":"CV","Path":"pdf/cv.pdf"},{"Title":"Grades","Path":"pdf/grades.pdf"}]` {
+		t.Fatalf("PDFs were not written back: %s", jsonField)
+	}
+}
+
+func TestSQLiteFileReferences(t *testing.T) {
+	store := openTestSQLite(t)
+	defer store.CloseConnection()
+
+	store.AddUser(&User{Email: "dave@example.com", UserID: "dave", Password: "hash", Salt: "salt"})
+	store.UpdateUserContent("dave", &UserContents{EMail: "dave@example.com", ProfileIcon: "img/profile-icons/dave.png", PDFs: []PDF{{Title: "CV", Path: "pdf/dave.pdf"}}})
+	for _, path := range []string{"img/profile-icons/dave.png", "pdf/dave.pdf"} {
+		if referenced, err := store.IsFileReferenced(path); !referenced || err != nil {
+			t.Fatalf("%s should be referenced (%v)", path, err)
+		}
+	}
+
+	store.UpdateUserContent("dave", &UserContents{EMail: "dave@example.com", ProfileIcon: "img/profile-icons/new.png"})
+	if referenced, _ := store.IsFileReferenced("pdf/dave.pdf"); referenced {
+		t.Fatalf("Removed pdf should no longer be referenced")
+	}
+}
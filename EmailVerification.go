@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//Verification links stay valid long enough for users who don't read mail every day
+const emailVerificationLifetime = time.Hour * 24 * 7
+
+//Things unverified accounts can be kept from doing with the -unverified flag
+const (
+	RestrictLogin   = "login"
+	RestrictPublish = "publish"
+)
+
+//VerificationRequest is sent by the client to confirm an email address
+type VerificationRequest struct {
+	Token string
+}
+
+//sendVerificationMail mails the user a link that confirms their email address
+func sendVerificationMail(user *User) error {
+	token, err := issueOneTimeToken(user, TokenPurposeVerifyEmail, emailVerificationLifetime)
+	if err != nil {
+		return err
+	}
+	return sendMail(user.Email, "verify-email", MailLink{*publicURL + "/#/verify/" + token})
+}
+
+//Confirms the email address of the user the verification token was mailed to
+func verifyEmail(w http.ResponseWriter, r *http.Request) {
+	if !usingDatabase(w) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	request := new(VerificationRequest)
+	if err == nil {
+		err = json.Unmarshal(body, request)
+	}
+	if err != nil || request.Token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("No verification token provided"))
+		return
+	}
+
+	token, err := db.UseOneTimeToken(hashToken(request.Token), TokenPurposeVerifyEmail)
+	if err != nil || !token.ExpiresAt.After(time.Now()) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid or expired verification link"))
+		return
+	}
+	if err = db.SetEmailVerified(token.UserID); err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to verify email"))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("Your email has been verified"))
+}
+
+//Mails a new verification link to the logged in user
+func resendVerification(w http.ResponseWriter, r *http.Request) {
+	if !usingDatabase(w) {
+		return
+	}
+
+	user, err := handleToken(w, r) //feedback to client happens inside function
+	if err != nil {
+		return
+	}
+	user, err = db.LookupUser(&User{UserID: user.UserID})
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("User not found"))
+		return
+	}
+	if user.EmailVerified {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Email is already verified"))
+		return
+	}
+	if err = sendVerificationMail(user); err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to send verification mail"))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("A new verification link has been sent"))
+}
+
+//restricted returns true if unverified accounts may not perform the action.
+//When registration is limited to domains only the owner of an address in
+//them may log in, so logging in always needs a verified email
+func restricted(action string) bool {
+	if action == RestrictLogin && registrationPolicy().Mode == RegistrationDomain {
+		return true
+	}
+	for _, restriction := range strings.Split(*unverifiedRestrictions, ",") {
+		if strings.TrimSpace(restriction) == action {
+			return true
+		}
+	}
+	return false
+}
+
+//allowedUnverified checks that the user may perform the action. Users that
+//haven't verified their email are told to do so if the action is restricted
+func allowedUnverified(w http.ResponseWriter, user *User, action string) bool {
+	if !restricted(action) {
+		return true
+	}
+	if !user.EmailVerified {
+		stored, err := db.LookupUser(&User{UserID: user.UserID})
+		if err != nil || !stored.EmailVerified {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Please verify your email first, we have sent you a link"))
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+//How much of the start and end of a file is read to recognize it
+const (
+	sniffHeadLength = 64
+	sniffTailLength = 1024
+)
+
+var (
+	//ErrUnsupportedFileType if the content of an upload is not one of the
+	//types allowed for its kind, whatever its name says
+	ErrUnsupportedFileType = errors.New("Unsupported file type")
+
+	pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+
+	//fileTypes are the types of files that can be uploaded. They are
+	//recognized by their content, never by the name the client gave
+	fileTypes = map[string]*FileType{
+		"pdf":  {Name: "PDF", Extension: ".pdf", valid: validPDF},
+		"png":  {Name: "PNG", Extension: ".png", valid: validPNG},
+		"jpeg": {Name: "JPEG", Extension: ".jpg", valid: validJPEG},
+		"gif":  {Name: "GIF", Extension: ".gif", valid: validGIF},
+		"webp": {Name: "WebP", Extension: ".webp", valid: validWebP},
+	}
+
+	//uploadFileTypes lists the types every kind of upload may be
+	uploadFileTypes = map[string][]string{
+		FileKindPDF:           {"pdf"},
+		FileKindProfileHeader: {"png", "jpeg", "gif", "webp"},
+		FileKindProfileIcon:   {"png", "jpeg", "gif", "webp"},
+	}
+)
+
+//FileType is a type of file recognized by its content
+type FileType struct {
+	Name      string
+	Extension string //Files of the type are stored with this extension
+	valid     func(head, tail []byte, size int64) bool
+}
+
+//sniffFileType returns the type of the file if it is one of the types
+//allowed for the kind of upload, otherwise ErrUnsupportedFileType
+func sniffFileType(kind string, file io.ReaderAt, size int64) (*FileType, error) {
+	head := make([]byte, sniffHeadLength)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	tailStart := size - sniffTailLength
+	if tailStart < 0 {
+		tailStart = 0
+	}
+	tail := make([]byte, size-tailStart)
+	n, err = file.ReadAt(tail, tailStart)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	tail = tail[:n]
+
+	for _, name := range uploadFileTypes[kind] {
+		if fileType := fileTypes[name]; fileType.valid(head, tail, size) {
+			return fileType, nil
+		}
+	}
+	return nil, ErrUnsupportedFileType
+}
+
+//unsupportedFileMessage tells the client which types the kind of upload may be
+func unsupportedFileMessage(kind string) string {
+	names := make([]string, len(uploadFileTypes[kind]))
+	for i, name := range uploadFileTypes[kind] {
+		names[i] = fileTypes[name].Name
+	}
+	if len(names) > 1 {
+		names = append(names[:len(names)-2], names[len(names)-2]+" or "+names[len(names)-1])
+	}
+	return "Unsupported file type, " + kind + " uploads have to be " + strings.Join(names, ", ")
+}
+
+//validPDF checks for the version header and the end of file marker
+func validPDF(head, tail []byte, size int64) bool {
+	return (bytes.HasPrefix(head, []byte("%PDF-1.")) || bytes.HasPrefix(head, []byte("%PDF-2."))) &&
+		bytes.Contains(tail, []byte("%%EOF"))
+}
+
+//validPNG checks for the signature, that the first chunk is the image
+//header and that the file ends with the image end chunk
+func validPNG(head, tail []byte, size int64) bool {
+	return len(head) >= 16 &&
+		bytes.HasPrefix(head, pngSignature) &&
+		binary.BigEndian.Uint32(head[8:12]) == 13 &&
+		string(head[12:16]) == "IHDR" &&
+		bytes.Contains(tail, []byte("IEND"))
+}
+
+//validJPEG checks for the start of image marker followed by another
+//marker, and the end of image marker
+func validJPEG(head, tail []byte, size int64) bool {
+	return len(head) >= 4 &&
+		head[0] == 0xff && head[1] == 0xd8 && head[2] == 0xff &&
+		bytes.Contains(tail, []byte{0xff, 0xd9})
+}
+
+//validGIF checks for the signature, a complete screen descriptor and the trailer
+func validGIF(head, tail []byte, size int64) bool {
+	return size > 13 &&
+		(bytes.HasPrefix(head, []byte("GIF87a")) || bytes.HasPrefix(head, []byte("GIF89a"))) &&
+		len(tail) > 0 && tail[len(tail)-1] == 0x3b
+}
+
+//validWebP checks for a RIFF container of the WEBP form that fits in
+//the file and starts with one of the VP8 chunks
+func validWebP(head, tail []byte, size int64) bool {
+	if len(head) < 16 || string(head[0:4]) != "RIFF" || string(head[8:12]) != "WEBP" {
+		return false
+	}
+	riffSize := int64(binary.LittleEndian.Uint32(head[4:8]))
+	chunk := string(head[12:16])
+	return riffSize+8 <= size && (chunk == "VP8 " || chunk == "VP8L" || chunk == "VP8X")
+}
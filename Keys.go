@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	//ErrUnknownKey if no key with the requested id is in the key ring
+	ErrUnknownKey = errors.New("No signing key with the specified id")
+
+	//ErrRetireSigningKey if trying to retire the key currently used for signing
+	ErrRetireSigningKey = errors.New("The current signing key can not be retired, rotate first")
+
+	//ErrUnsupportedAlgorithm if a key uses an algorithm we can't sign with
+	ErrUnsupportedAlgorithm = errors.New("Unsupported signing algorithm, use HS256, RS256 or EdDSA")
+
+	//Keys published by others are fetched again when an unknown id shows
+	//up, but not more often than this
+	keyReloadInterval = time.Second * 10
+
+	//The key file is checked for changes made by other instances this often
+	keyFileCheckInterval = time.Second * 10
+)
+
+func init() {
+	//jwt-go has no implementation of EdDSA
+	jwt.RegisterSigningMethod("EdDSA", func() jwt.SigningMethod {
+		return signingMethodEdDSA{}
+	})
+}
+
+//SigningKey is a key web tokens are signed and validated with. For HS256
+//Secret is the shared secret, for RS256 and EdDSA it is the PKCS8 encoded
+//private key and the public key can be published for others to verify with
+type SigningKey struct {
+	ID        string //Sent as the kid header of every token signed with the key
+	Algorithm string
+	Secret    []byte
+	Created   time.Time
+	private   crypto.Signer
+}
+
+//JSONWebKey is the public part of a key as described in RFC 7517
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+//KeyRing holds every key tokens are accepted with. The newest key
+//is used for signing, older keys are kept so that tokens signed before
+//a rotation stay valid until the key is retired. It is safe for concurrent use
+type KeyRing struct {
+	mutex     sync.RWMutex
+	path      string //Empty for key rings that only live in memory
+	keys      []*SigningKey
+	file      os.FileInfo //Of the key file when it was last read or written
+	lastCheck time.Time
+}
+
+//newEphemeralKeyRing returns a key ring with one random HS256 key
+//that is never written to disk
+func newEphemeralKeyRing() *KeyRing {
+	key, _ := newSigningKey("HS256")
+	ring := new(KeyRing)
+	ring.keys = []*SigningKey{key}
+	return ring
+}
+
+//LoadKeyRing reads the keys in the file at path. If the file does not
+//exist it is created with a fresh key using the algorithm
+func LoadKeyRing(path, algorithm string) (*KeyRing, error) {
+	ring := &KeyRing{path: path}
+	err := ring.reload()
+	if os.IsNotExist(err) {
+		key, err := newSigningKey(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		ring.keys = []*SigningKey{key}
+		return ring, ring.save()
+	}
+	return ring, err
+}
+
+//newSigningKey generates a random key for the algorithm
+func newSigningKey(algorithm string) (*SigningKey, error) {
+	key := &SigningKey{
+		ID:        randBase64String(12),
+		Algorithm: algorithm,
+		Created:   time.Now().UTC(),
+	}
+
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case "HS256":
+		key.Secret = []byte(randBase64String(128))
+		return key, nil
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+	if err != nil {
+		return nil, err
+	}
+	key.Secret, err = x509.MarshalPKCS8PrivateKey(private)
+	key.private = private
+	return key, err
+}
+
+//parse reads the private key of asymmetric keys
+func (key *SigningKey) parse() error {
+	switch key.Algorithm {
+	case "HS256":
+		return nil
+	case "RS256", "EdDSA":
+		private, err := x509.ParsePKCS8PrivateKey(key.Secret)
+		if err != nil {
+			return err
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return ErrUnsupportedAlgorithm
+		}
+		_, isRSA := private.(*rsa.PrivateKey)
+		_, isEd25519 := private.(ed25519.PrivateKey)
+		if (key.Algorithm == "RS256" && !isRSA) || (key.Algorithm == "EdDSA" && !isEd25519) {
+			return fmt.Errorf("Key %s is not a %s key", key.ID, key.Algorithm)
+		}
+		key.private = signer
+		return nil
+	}
+	return ErrUnsupportedAlgorithm
+}
+
+//SigningMethod returns the jwt signing method of the key
+func (key *SigningKey) SigningMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(key.Algorithm)
+}
+
+//SignKey returns what the signing method signs with
+func (key *SigningKey) SignKey() interface{} {
+	if key.private != nil {
+		return key.private
+	}
+	return key.Secret
+}
+
+//VerifyKey returns what the signing method verifies with
+func (key *SigningKey) VerifyKey() interface{} {
+	if key.private != nil {
+		return key.private.Public()
+	}
+	return key.Secret
+}
+
+//JWK returns the public key in JSON Web Key format.
+//Returns false for symmetric keys, which must never be published
+func (key *SigningKey) JWK() (JSONWebKey, bool) {
+	jwk := JSONWebKey{ID: key.ID, Algorithm: key.Algorithm, Use: "sig"}
+	switch public := key.VerifyKey().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = jwt.EncodeSegment(public.N.Bytes())
+		jwk.E = jwt.EncodeSegment(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = jwt.EncodeSegment(public)
+	default:
+		return jwk, false
+	}
+	return jwk, true
+}
+
+//PublicKey returns the key described by the JWK in the form the jwt
+//signing methods verify with. Used for keys published by others
+func (jwk JSONWebKey) PublicKey() (interface{}, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := jwt.DecodeSegment(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := jwt.DecodeSegment(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if jwk.Curve != "P-256" {
+			return nil, fmt.Errorf("Unsupported curve %s", jwk.Curve)
+		}
+		x, err := jwt.DecodeSegment(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := jwt.DecodeSegment(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return nil, fmt.Errorf("Key %s is not on its curve", jwk.ID)
+		}
+		return public, nil
+	case "OKP":
+		x, err := jwt.DecodeSegment(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if jwk.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Unsupported curve %s", jwk.Curve)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("Unsupported key type %s", jwk.KeyType)
+}
+
+//SigningKey returns the key new tokens should be signed with
+func (ring *KeyRing) SigningKey() *SigningKey {
+	ring.refresh(false)
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+
+	return ring.keys[len(ring.keys)-1]
+}
+
+//Key returns the key with the id. If the id is unknown the key file is
+//checked right away, since another server instance might have rotated the keys
+func (ring *KeyRing) Key(id string) (*SigningKey, error) {
+	ring.refresh(false)
+	if key := ring.find(id); key != nil {
+		return key, nil
+	}
+	ring.refresh(true)
+	if key := ring.find(id); key != nil {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+//refresh reloads the key file if another instance has replaced or changed
+//it since it was last read. Unless forced the file is checked once per keyFileCheckInterval
+func (ring *KeyRing) refresh(force bool) {
+	if ring.path == "" {
+		return
+	}
+	ring.mutex.Lock()
+	if !force && time.Since(ring.lastCheck) < keyFileCheckInterval {
+		ring.mutex.Unlock()
+		return
+	}
+	ring.lastCheck = time.Now()
+	known := ring.file
+	ring.mutex.Unlock()
+
+	info, err := os.Stat(ring.path)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	//Saving renames a new file into place, editing by hand changes the time
+	if known != nil && os.SameFile(info, known) && info.ModTime().Equal(known.ModTime()) && info.Size() == known.Size() {
+		return
+	}
+	if err = ring.reload(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+//Keys returns every key in the ring, oldest first
+func (ring *KeyRing) Keys() []*SigningKey {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+
+	return append([]*SigningKey(nil), ring.keys...)
+}
+
+//JWKS returns the public keys of every asymmetric key in the ring
+func (ring *KeyRing) JWKS() []JSONWebKey {
+	jwks := []JSONWebKey{}
+	for _, key := range ring.Keys() {
+		if jwk, ok := key.JWK(); ok {
+			jwks = append(jwks, jwk)
+		}
+	}
+	return jwks
+}
+
+//Rotate adds a new key using the algorithm which is used for signing
+//from now on. Tokens signed with the previous keys remain valid
+func (ring *KeyRing) Rotate(algorithm string) (*SigningKey, error) {
+	key, err := newSigningKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	ring.refresh(true) //Keep what other instances have changed
+	ring.mutex.Lock()
+	ring.keys = append(ring.keys, key)
+	ring.mutex.Unlock()
+
+	return key, ring.save()
+}
+
+//Retire removes a key so tokens signed with it are no longer accepted
+func (ring *KeyRing) Retire(id string) error {
+	ring.refresh(true) //Keep what other instances have changed
+	ring.mutex.Lock()
+	index := -1
+	for i, key := range ring.keys {
+		if key.ID == id {
+			index = i
+		}
+	}
+	if index == -1 {
+		ring.mutex.Unlock()
+		return ErrUnknownKey
+	}
+	if index == len(ring.keys)-1 {
+		ring.mutex.Unlock()
+		return ErrRetireSigningKey
+	}
+	ring.keys = append(ring.keys[:index], ring.keys[index+1:]...)
+	ring.mutex.Unlock()
+
+	return ring.save()
+}
+
+//find returns the key with the id or nil
+func (ring *KeyRing) find(id string) *SigningKey {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+
+	for _, key := range ring.keys {
+		if key.ID == id {
+			return key
+		}
+	}
+	return nil
+}
+
+//reload replaces the keys with the content of the key file. Every line
+//holds a key as: <id> <algorithm> <base64 secret> <created RFC3339>
+func (ring *KeyRing) reload() error {
+	f, err := os.Open(ring.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var keys []*SigningKey
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 4 {
+			return fmt.Errorf("Malformed line in key file %s", ring.path)
+		}
+		secret, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			return err
+		}
+		created, err := time.Parse(time.RFC3339, fields[3])
+		if err != nil {
+			return err
+		}
+		key := &SigningKey{ID: fields[0], Algorithm: fields[1], Secret: secret, Created: created}
+		if err = key.parse(); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("No keys in key file %s", ring.path)
+	}
+
+	ring.mutex.Lock()
+	ring.keys = keys
+	ring.file = info
+	ring.mutex.Unlock()
+	return nil
+}
+
+//save writes the keys to the key file, readable by the owner only.
+//The file is replaced atomically so other instances never read half a file
+func (ring *KeyRing) save() error {
+	if ring.path == "" {
+		return nil
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("# Malicious Mango signing keys, the last key is used for signing\n")
+	for _, key := range ring.Keys() {
+		fmt.Fprintf(&buffer, "%s %s %s %s\n", key.ID, key.Algorithm, base64.StdEncoding.EncodeToString(key.Secret), key.Created.Format(time.RFC3339))
+	}
+
+	//Every save writes a temporary file of its own, CreateTemp makes it readable by the owner only
+	temp, err := os.CreateTemp(filepath.Dir(ring.path), filepath.Base(ring.path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name()) //Nothing to remove once it has been renamed
+	_, err = temp.Write(buffer.Bytes())
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(temp.Name(), ring.path); err != nil {
+		return err
+	}
+	if info, err := os.Stat(ring.path); err == nil {
+		ring.mutex.Lock()
+		ring.file = info
+		ring.mutex.Unlock()
+	}
+	return nil
+}
+
+//signingMethodEdDSA implements the EdDSA algorithm of RFC 8037 for ed25519 keys
+type signingMethodEdDSA struct{}
+
+func (signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKey
+	}
+	return jwt.EncodeSegment(ed25519.Sign(private, []byte(signingString))), nil
+}
+
+func (signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKey
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(public, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
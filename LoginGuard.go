@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//Failed logins allowed before every further failure locks for a while.
+	//Addresses get more since a whole office can share one
+	loginAccountAttempts = 5
+	loginIPAttempts      = 20
+
+	//The first lockout lasts loginBaseLockout and every further failure
+	//doubles it, up to loginMaxLockout
+	loginBaseLockout = time.Second
+	loginMaxLockout  = time.Minute * 15
+
+	//Failures are forgotten when there has been none for this long
+	loginFailureWindow = time.Hour
+)
+
+//loginGuard keeps track of failed logins for the whole server
+var loginGuard = NewLoginGuard()
+
+//Lockout describes the failed logins of an account or address
+type Lockout struct {
+	Key         string //email:<email> or ip:<address>
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+//LoginGuard counts failed logins per account and per address and locks
+//them out for exponentially longer after too many. It is safe for
+//concurrent use. Failures are kept in memory so a restart clears them
+type LoginGuard struct {
+	mutex    sync.Mutex
+	lockouts map[string]*Lockout
+}
+
+//NewLoginGuard returns a LoginGuard without any failures
+func NewLoginGuard() *LoginGuard {
+	return &LoginGuard{lockouts: make(map[string]*Lockout)}
+}
+
+//accountKey and addressKey name what failures are counted against
+func accountKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func addressKey(ip string) string {
+	return "ip:" + ip
+}
+
+//Wait returns how long the longest lockout of the keys lasts, 0 if none is locked
+func (guard *LoginGuard) Wait(keys ...string) time.Duration {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+
+	var wait time.Duration
+	for _, key := range keys {
+		if lockout, ok := guard.lockouts[key]; ok {
+			if remaining := time.Until(lockout.LockedUntil); remaining > wait {
+				wait = remaining
+			}
+		}
+	}
+	return wait
+}
+
+//Fail records a failed login for the key, which is locked out once
+//it has failed more than allowed times
+func (guard *LoginGuard) Fail(key string, allowed int) {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+
+	now := time.Now()
+	lockout, ok := guard.lockouts[key]
+	if !ok || now.Sub(lockout.LastFailure) > loginFailureWindow {
+		lockout = &Lockout{Key: key}
+		guard.lockouts[key] = lockout
+	}
+	lockout.Failures++
+	lockout.LastFailure = now
+	if lockout.Failures > allowed {
+		lockout.LockedUntil = now.Add(lockoutDuration(lockout.Failures - allowed))
+	}
+}
+
+//Succeed forgets the failures of the key
+func (guard *LoginGuard) Succeed(key string) {
+	guard.Clear(key)
+}
+
+//Clear removes the failures of the key, returns false if it had none
+func (guard *LoginGuard) Clear(key string) bool {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+
+	_, ok := guard.lockouts[key]
+	delete(guard.lockouts, key)
+	return ok
+}
+
+//ClearAll removes every failure
+func (guard *LoginGuard) ClearAll() {
+	guard.mutex.Lock()
+	guard.lockouts = make(map[string]*Lockout)
+	guard.mutex.Unlock()
+}
+
+//Lockouts returns every key with failures, the most recent failure first
+func (guard *LoginGuard) Lockouts() []Lockout {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+
+	lockouts := make([]Lockout, 0, len(guard.lockouts))
+	for _, lockout := range guard.lockouts {
+		lockouts = append(lockouts, *lockout)
+	}
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].LastFailure.After(lockouts[j].LastFailure)
+	})
+	return lockouts
+}
+
+//Prune forgets failures older than the failure window whose lockout has ended
+func (guard *LoginGuard) Prune() {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+
+	now := time.Now()
+	for key, lockout := range guard.lockouts {
+		if now.Sub(lockout.LastFailure) > loginFailureWindow && now.After(lockout.LockedUntil) {
+			delete(guard.lockouts, key)
+		}
+	}
+}
+
+//lockoutDuration returns how long the nth failure past the allowed ones locks
+func lockoutDuration(n int) time.Duration {
+	if n > 20 {
+		return loginMaxLockout
+	}
+	duration := loginBaseLockout << uint(n-1)
+	if duration > loginMaxLockout {
+		return loginMaxLockout
+	}
+	return duration
+}
+
+//writeLockedOut tells the client to come back when the lockout has ended
+func writeLockedOut(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(wait/time.Second) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte("Too many failed login attempts, try again in " + strconv.Itoa(seconds) + " seconds"))
+}
+
+//clientIP returns the address a request was made from
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ProjectLemon/malicious-mango/mailer"
+)
+
+var (
+	//go:embed mailtemplates
+	mailTemplateFiles embed.FS
+
+	//mailTemplates holds every mail the server sends
+	mailTemplates *mailer.Templates
+
+	//mailSender is used by every handler that sends mail. Mail is printed to
+	//stdout until setupMail has read the configuration in .mail_cnf
+	mailSender mailer.Sender = &mailer.WriterSender{Writer: os.Stdout}
+
+	//mailQueue delivers the mail in the background, nil when not started
+	mailQueue *mailer.Queue
+
+	//How long shutting down waits for queued mail to be delivered
+	mailShutdownTimeout = time.Second * 10
+)
+
+func init() {
+	var err error
+	mailTemplates, err = mailer.ParseTemplates(mailTemplateFiles, "mailtemplates")
+	if err != nil {
+		panic(err)
+	}
+}
+
+//MailLink is the data of mails that ask the user to follow a link
+type MailLink struct {
+	Link string
+}
+
+//setupMail delivers mail through the smtp server in .mail_cnf, or into
+//the maildir given by -maildir. Mail is printed to stdout if neither is set.
+//Either way mail is sent from a queue so handlers never wait for it
+func setupMail() {
+	var sender mailer.Sender = &mailer.WriterSender{Writer: os.Stdout}
+	conf, err := os.Open(".mail_cnf")
+	if err == nil {
+		cnf := readConfigurations(conf)
+		conf.Close()
+		port := cnf["PORT"]
+		if port == "" {
+			port = "587"
+		}
+		sender = &mailer.SMTPSender{
+			Host:     cnf["HOST"],
+			Port:     port,
+			Username: cnf["USER"],
+			Password: cnf["PASSWORD"],
+			From:     cnf["FROM"],
+		}
+		fmt.Println("Sending mail through " + cnf["HOST"])
+	} else if *mailDir != "" {
+		sender = &mailer.MaildirSender{Dir: *mailDir}
+		fmt.Println("Writing mail to " + *mailDir)
+	}
+	mailQueue = mailer.NewQueue(sender, 100, 5, time.Second*30)
+	mailSender = mailQueue
+}
+
+//sendMail renders the mail template name with data and sends it to the address
+func sendMail(to, name string, data interface{}) error {
+	msg, err := mailTemplates.Render(name, data)
+	if err != nil {
+		return err
+	}
+	msg.To = []string{to}
+	return mailSender.Send(msg)
+}
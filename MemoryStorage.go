@@ -0,0 +1,854 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	//ErrUserAlreadyExists if the email is already registered
+	ErrUserAlreadyExists = errors.New("User is already registered")
+)
+
+func init() {
+	RegisterStorage("memory", func(cnf map[string]string) (Storage, error) {
+		return NewMemoryStorage(), nil
+	})
+}
+
+//MemoryStorage implements Storage by keeping everything in memory.
+//Nothing survives a restart, which makes it suitable for tests and
+//offline demos. It is safe for concurrent use
+type MemoryStorage struct {
+	mutex      sync.RWMutex
+	users      map[string]*User             //Keyed by lower case email
+	contents   map[string]*UserContents     //Keyed by user id
+	sessions   map[string]*memorySession    //Keyed by session key
+	references map[string]int               //Number of references to each file path
+	refresh    map[string]*RefreshToken     //Keyed by token hash
+	oneTime    map[string]*memoryToken      //Keyed by token hash
+	twoFactor  map[string]*TwoFactor        //Keyed by user id
+	recovery   map[string]map[string]bool   //Unused recovery code hashes keyed by user id
+	identities map[string]*ExternalIdentity //Keyed by issuer and subject
+	apiKeys    map[string]*APIKey           //Keyed by key hash
+	invites    map[string]*InviteCode       //Keyed by code hash
+	uploads    map[string]*Upload           //Keyed by path and user id
+	quotas     map[string]int64             //Keyed by user id, only users with a quota of their own
+
+	lastSessionID int64
+	lastAPIKeyID  int64
+	lastInviteID  int64
+}
+
+//memorySession is a row of the UserSession table
+type memorySession struct {
+	UserSession
+	UserID string
+}
+
+//memoryToken is a row of the OneTimeTokens table
+type memoryToken struct {
+	OneTimeToken
+	Used bool
+}
+
+//NewMemoryStorage returns an empty MemoryStorage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		users:      make(map[string]*User),
+		contents:   make(map[string]*UserContents),
+		sessions:   make(map[string]*memorySession),
+		references: make(map[string]int),
+		refresh:    make(map[string]*RefreshToken),
+		oneTime:    make(map[string]*memoryToken),
+		twoFactor:  make(map[string]*TwoFactor),
+		recovery:   make(map[string]map[string]bool),
+		identities: make(map[string]*ExternalIdentity),
+		apiKeys:    make(map[string]*APIKey),
+		invites:    make(map[string]*InviteCode),
+		uploads:    make(map[string]*Upload),
+		quotas:     make(map[string]int64),
+	}
+}
+
+//IsFileReferenced returns true if any profile refers to the file at path
+func (ms *MemoryStorage) IsFileReferenced(path string) (bool, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	return ms.references[path] > 0, nil
+}
+
+//UniqueIdentifier returns true if no user has the identifier as user id or salt
+func (ms *MemoryStorage) UniqueIdentifier(identifier string) bool {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	for _, u := range ms.users {
+		if u.UserID == identifier || u.Salt == identifier {
+			return false
+		}
+	}
+	return true
+}
+
+//LookupUser finds the user by email or user id and fills in the provided struct
+func (ms *MemoryStorage) LookupUser(user *User) (*User, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	stored := ms.findUser(user)
+	if stored == nil {
+		return nil, ErrNoUserFound
+	}
+	user.Email = stored.Email
+	user.UserID = stored.UserID
+	user.Password = stored.Password
+	user.Salt = stored.Salt
+	user.EmailVerified = stored.EmailVerified
+	return user, nil
+}
+
+//GetUserIDFromPublicName returns the id of the user with the public name
+func (ms *MemoryStorage) GetUserIDFromPublicName(name string) (string, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	for uid, uc := range ms.contents {
+		if strings.EqualFold(uc.PublicName, name) {
+			return uid, nil
+		}
+	}
+	return "", nil
+}
+
+//AddUser stores the user together with an empty UserContents
+func (ms *MemoryStorage) AddUser(user *User) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	return ms.addUser(user)
+}
+
+//addUser stores the user, caller must hold the lock
+func (ms *MemoryStorage) addUser(user *User) error {
+	key := strings.ToLower(user.Email)
+	if _, exists := ms.users[key]; exists {
+		return ErrUserAlreadyExists
+	}
+	ms.users[key] = &User{
+		Email:         user.Email,
+		UserID:        user.UserID,
+		Password:      user.Password,
+		Salt:          user.Salt,
+		EmailVerified: user.EmailVerified,
+	}
+	ms.contents[user.UserID] = &UserContents{UserID: user.UserID}
+	return nil
+}
+
+//LookupPublicName performs a case insensitive regexp match against every public name
+func (ms *MemoryStorage) LookupPublicName(name string) (bool, error) {
+	regex, err := regexp.Compile("(?i)" + name)
+	if err != nil {
+		return false, err
+	}
+
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	for _, uc := range ms.contents {
+		if regex.MatchString(uc.PublicName) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//UpdatePublicName overwrites the public name of the user
+func (ms *MemoryStorage) UpdatePublicName(uc *UserContents, user *User) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if stored, ok := ms.contents[user.UserID]; ok {
+		stored.PublicName = uc.PublicName
+	}
+	return nil
+}
+
+//GetUserContents copies the content of the user into userContent
+func (ms *MemoryStorage) GetUserContents(uid string, userContent *UserContents) (*UserContents, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	stored, ok := ms.contents[uid]
+	if !ok || !contentInDatabase(stored) {
+		return nil, ErrNoContentInDatabase
+	}
+	*userContent = *stored
+	userContent.PDFs = append([]PDF(nil), stored.PDFs...)
+	return userContent, nil
+}
+
+//UpdateUserContent overwrites the content of the user, leaving the public name as is
+func (ms *MemoryStorage) UpdateUserContent(uid string, uc *UserContents) error {
+	if validateUserContent(uc) {
+		return errors.New("Invalid content")
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	stored, ok := ms.contents[uid]
+	if !ok {
+		return nil
+	}
+	//Pdfs that are kept keep the time they were first added
+	uploadTimes := make(map[string]time.Time)
+	for i := range stored.PDFs {
+		uploadTimes[stored.PDFs[i].Path] = stored.PDFs[i].UploadTime
+	}
+
+	for _, reference := range fileReferences(uid, stored) {
+		ms.references[reference.Path]--
+		if ms.references[reference.Path] <= 0 {
+			delete(ms.references, reference.Path)
+		}
+	}
+	for _, reference := range fileReferences(uid, uc) {
+		ms.references[reference.Path]++
+	}
+
+	publicName := stored.PublicName
+	*stored = *uc
+	stored.UserID = uid
+	stored.PublicName = publicName
+	stored.PDFs = append([]PDF(nil), uc.PDFs...)
+	for i := range stored.PDFs {
+		uploadTime, ok := uploadTimes[stored.PDFs[i].Path]
+		if !ok {
+			uploadTime = time.Now()
+		}
+		stored.PDFs[i].Order = i
+		stored.PDFs[i].UploadTime = uploadTime
+	}
+	return nil
+}
+
+//InsertUserSession creates a new session for the user's token
+func (ms *MemoryStorage) InsertUserSession(user *User) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if user.Session == nil {
+		user.Session = new(UserSession)
+	}
+	now := time.Now()
+	ms.lastSessionID++
+	user.Session.ID = ms.lastSessionID
+	user.Session.SessionKey = user.Token
+	user.Session.LoginTime = now
+	user.Session.LastSeen = now
+	ms.sessions[user.Token] = &memorySession{UserSession: *user.Session, UserID: user.UserID}
+	return nil
+}
+
+//UpdateUserSession overwrites the token and last seen time of the session in user.Session
+func (ms *MemoryStorage) UpdateUserSession(user *User) error {
+	if user.Session == nil {
+		return ErrNoActiveSession
+	}
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for key, s := range ms.sessions {
+		if s.ID == user.Session.ID && s.UserID == user.UserID {
+			delete(ms.sessions, key)
+			s.SessionKey = user.Token
+			s.LastSeen = time.Now()
+			ms.sessions[user.Token] = s
+		}
+	}
+	return nil
+}
+
+//GetUserSession reads the session belonging to the user's token
+func (ms *MemoryStorage) GetUserSession(user *User) (*User, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	s, ok := ms.sessions[user.Token]
+	if !ok {
+		return nil, ErrNoActiveSession
+	}
+	session := s.UserSession
+	user.Session = &session
+	user.UserID = s.UserID
+	return user, nil
+}
+
+//GetUserSessions returns every session of the user ordered by login time
+func (ms *MemoryStorage) GetUserSessions(uid string) ([]*UserSession, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	sessions := []*UserSession{}
+	for _, s := range ms.sessions {
+		if s.UserID == uid {
+			session := s.UserSession
+			sessions = append(sessions, &session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions, nil
+}
+
+//RemoveUserSession removes the session with the session key
+func (ms *MemoryStorage) RemoveUserSession(session *UserSession) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if s, ok := ms.sessions[session.SessionKey]; ok {
+		ms.removeSession(s)
+	}
+	return nil
+}
+
+//RemoveUserSessionByID removes a single session of the user
+func (ms *MemoryStorage) RemoveUserSessionByID(uid string, id int64) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for _, s := range ms.sessions {
+		if s.ID == id && s.UserID == uid {
+			ms.removeSession(s)
+			return nil
+		}
+	}
+	return ErrNoActiveSession
+}
+
+//RemoveUserSessions removes every session of the user
+func (ms *MemoryStorage) RemoveUserSessions(uid string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for _, s := range ms.sessions {
+		if s.UserID == uid {
+			ms.removeSession(s)
+		}
+	}
+	return nil
+}
+
+//CleanUserSession removes sessions not seen for as long as a refresh
+//token lives and every expired token
+func (ms *MemoryStorage) CleanUserSession() error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	now := time.Now()
+	limit := now.Add(-refreshTokenLifetime)
+	for _, s := range ms.sessions {
+		if !s.LastSeen.After(limit) {
+			ms.removeSession(s)
+		}
+	}
+	for hash, token := range ms.refresh {
+		if !token.ExpiresAt.After(now) {
+			delete(ms.refresh, hash)
+		}
+	}
+	for hash, token := range ms.oneTime {
+		if !token.ExpiresAt.After(now) {
+			delete(ms.oneTime, hash)
+		}
+	}
+	return nil
+}
+
+//InsertRefreshToken stores a newly issued refresh token
+func (ms *MemoryStorage) InsertRefreshToken(token *RefreshToken) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	found := false
+	for _, s := range ms.sessions {
+		found = found || (s.ID == token.SessionID && s.UserID == token.UserID)
+	}
+	if !found {
+		return ErrNoActiveSession
+	}
+	stored := *token
+	ms.refresh[token.Hash] = &stored
+	return nil
+}
+
+//UseRefreshToken marks the refresh token as used and returns it. Tokens
+//that were already used are returned together with ErrRefreshTokenReused
+func (ms *MemoryStorage) UseRefreshToken(hash string) (*RefreshToken, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	stored, ok := ms.refresh[hash]
+	if !ok {
+		return nil, ErrNoRefreshToken
+	}
+	token := *stored
+	if stored.Used {
+		return &token, ErrRefreshTokenReused
+	}
+	stored.Used = true
+	token.Used = true
+	return &token, nil
+}
+
+//InsertOneTimeToken stores a newly issued one time token
+func (ms *MemoryStorage) InsertOneTimeToken(token *OneTimeToken) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.oneTime[token.Hash] = &memoryToken{OneTimeToken: *token}
+	return nil
+}
+
+//UseOneTimeToken marks the token as used and returns it. Returns
+//ErrNoOneTimeToken if there is no unused token with the hash and purpose
+func (ms *MemoryStorage) UseOneTimeToken(hash, purpose string) (*OneTimeToken, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	stored, ok := ms.oneTime[hash]
+	if !ok || stored.Used || stored.Purpose != purpose {
+		return nil, ErrNoOneTimeToken
+	}
+	stored.Used = true
+	token := stored.OneTimeToken
+	return &token, nil
+}
+
+//RemoveOneTimeTokens removes the unused tokens issued to the user for the purpose
+func (ms *MemoryStorage) RemoveOneTimeTokens(uid, purpose string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for hash, stored := range ms.oneTime {
+		if stored.UserID == uid && stored.Purpose == purpose && !stored.Used {
+			delete(ms.oneTime, hash)
+		}
+	}
+	return nil
+}
+
+//UpdatePassword overwrites the password hash and salt of the user
+func (ms *MemoryStorage) UpdatePassword(user *User) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	stored := ms.findUser(&User{UserID: user.UserID})
+	if stored == nil {
+		return ErrNoUserFound
+	}
+	stored.Password = user.Password
+	stored.Salt = user.Salt
+	return nil
+}
+
+//SetEmailVerified marks the email of the user as verified
+func (ms *MemoryStorage) SetEmailVerified(uid string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	stored := ms.findUser(&User{UserID: uid})
+	if stored == nil {
+		return ErrNoUserFound
+	}
+	stored.EmailVerified = true
+	return nil
+}
+
+//GetTwoFactor returns the two-factor settings of the user
+func (ms *MemoryStorage) GetTwoFactor(uid string) (*TwoFactor, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	stored, ok := ms.twoFactor[uid]
+	if !ok {
+		return nil, ErrNoTwoFactor
+	}
+	tf := *stored
+	return &tf, nil
+}
+
+//SaveTwoFactor replaces the two-factor settings of the user
+func (ms *MemoryStorage) SaveTwoFactor(tf *TwoFactor) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	stored := *tf
+	stored.Created = time.Now()
+	ms.twoFactor[tf.UserID] = &stored
+	return nil
+}
+
+//RemoveTwoFactor turns two-factor authentication off for the user
+func (ms *MemoryStorage) RemoveTwoFactor(uid string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	delete(ms.twoFactor, uid)
+	delete(ms.recovery, uid)
+	return nil
+}
+
+//UseTwoFactorStep records that a code of the time step has been accepted
+func (ms *MemoryStorage) UseTwoFactorStep(uid string, step int64) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	stored, ok := ms.twoFactor[uid]
+	if !ok || stored.LastStep >= step {
+		return ErrTwoFactorReplayed
+	}
+	stored.LastStep = step
+	return nil
+}
+
+//SetRecoveryCodes replaces the recovery codes of the user with the hashes
+func (ms *MemoryStorage) SetRecoveryCodes(uid string, hashes []string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.recovery[uid] = make(map[string]bool)
+	for _, hash := range hashes {
+		ms.recovery[uid][hash] = true
+	}
+	return nil
+}
+
+//UseRecoveryCode removes the recovery code with the hash
+func (ms *MemoryStorage) UseRecoveryCode(uid, hash string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if !ms.recovery[uid][hash] {
+		return ErrNoRecoveryCode
+	}
+	delete(ms.recovery[uid], hash)
+	return nil
+}
+
+//GetExternalIdentity returns the link of the account at the identity provider
+func (ms *MemoryStorage) GetExternalIdentity(issuer, subject string) (*ExternalIdentity, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	identity, ok := ms.identities[issuer+" "+subject]
+	if !ok {
+		return nil, ErrNoExternalIdentity
+	}
+	copied := *identity
+	return &copied, nil
+}
+
+//AddExternalIdentity links an account at an identity provider to a user
+func (ms *MemoryStorage) AddExternalIdentity(identity *ExternalIdentity) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	key := identity.Issuer + " " + identity.Subject
+	if _, exists := ms.identities[key]; exists {
+		return errors.New("External account is already linked")
+	}
+	stored := *identity
+	stored.Created = time.Now()
+	ms.identities[key] = &stored
+	return nil
+}
+
+//InsertAPIKey stores a new api key and sets its id
+func (ms *MemoryStorage) InsertAPIKey(key *APIKey) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.lastAPIKeyID++
+	key.ID = ms.lastAPIKeyID
+	key.Created = time.Now()
+	stored := *key
+	stored.Scopes = append([]string(nil), key.Scopes...)
+	ms.apiKeys[key.Hash] = &stored
+	return nil
+}
+
+//UseAPIKey returns the api key with the hash and records that it was used
+func (ms *MemoryStorage) UseAPIKey(hash string) (*APIKey, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	stored, ok := ms.apiKeys[hash]
+	if !ok {
+		return nil, ErrNoAPIKey
+	}
+	key := *stored
+	stored.LastUsed = time.Now()
+	return &key, nil
+}
+
+//GetAPIKeys returns every api key of the user, oldest first
+func (ms *MemoryStorage) GetAPIKeys(uid string) ([]*APIKey, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	keys := []*APIKey{}
+	for _, stored := range ms.apiKeys {
+		if stored.UserID == uid {
+			key := *stored
+			keys = append(keys, &key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+//RemoveAPIKey revokes one api key of the user
+func (ms *MemoryStorage) RemoveAPIKey(uid string, id int64) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for hash, stored := range ms.apiKeys {
+		if stored.UserID == uid && stored.ID == id {
+			delete(ms.apiKeys, hash)
+			return nil
+		}
+	}
+	return ErrNoAPIKey
+}
+
+//InsertInviteCode stores a new invite code and sets its id
+func (ms *MemoryStorage) InsertInviteCode(code *InviteCode) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.lastInviteID++
+	code.ID = ms.lastInviteID
+	code.Created = time.Now()
+	code.Uses = 0
+	stored := *code
+	ms.invites[code.Hash] = &stored
+	return nil
+}
+
+//AddUserWithInvite stores the user like AddUser while counting a use of
+//the invite code with the hash, if it has not expired or been used as many
+//times as allowed. Either both happen or neither does
+func (ms *MemoryStorage) AddUserWithInvite(user *User, hash string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	code, ok := ms.invites[hash]
+	if !ok || code.Uses >= code.MaxUses || (!code.ExpiresAt.IsZero() && !code.ExpiresAt.After(time.Now())) {
+		return ErrNoInviteCode
+	}
+	if err := ms.addUser(user); err != nil {
+		return err
+	}
+	code.Uses++
+	return nil
+}
+
+//GetInviteCodes returns every invite code, oldest first
+func (ms *MemoryStorage) GetInviteCodes() ([]*InviteCode, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	codes := []*InviteCode{}
+	for _, stored := range ms.invites {
+		code := *stored
+		codes = append(codes, &code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i].ID < codes[j].ID
+	})
+	return codes, nil
+}
+
+//RemoveInviteCode revokes an invite code
+func (ms *MemoryStorage) RemoveInviteCode(id int64) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for hash, stored := range ms.invites {
+		if stored.ID == id {
+			delete(ms.invites, hash)
+			return nil
+		}
+	}
+	return ErrNoInviteCode
+}
+
+//InsertUpload records a file stored by an upload, unless it would take the
+//files of the user over quota, which gives ErrQuotaExceeded
+func (ms *MemoryStorage) InsertUpload(upload *Upload, quota int64) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	key := upload.Path + "\x00" + upload.UserID
+	if _, ok := ms.uploads[key]; ok {
+		return errors.New("File has already been uploaded by the user")
+	}
+	used := upload.Size
+	for _, stored := range ms.uploads {
+		if stored.UserID == upload.UserID {
+			used += stored.Size
+		}
+	}
+	if used > quota {
+		return ErrQuotaExceeded
+	}
+	upload.Created = time.Now()
+	stored := *upload
+	ms.uploads[key] = &stored
+	return nil
+}
+
+//GetUpload returns the upload of the file at path by the user
+func (ms *MemoryStorage) GetUpload(path, uid string) (*Upload, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	stored, ok := ms.uploads[path+"\x00"+uid]
+	if !ok {
+		return nil, ErrNoUpload
+	}
+	upload := *stored
+	return &upload, nil
+}
+
+//CountUploads returns how many users have uploaded the file at path
+func (ms *MemoryStorage) CountUploads(path string) (int, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	count := 0
+	for _, upload := range ms.uploads {
+		if upload.Path == path {
+			count++
+		}
+	}
+	return count, nil
+}
+
+//GetUnreferencedUploads returns the uploads created before the time
+//that the profile of their user doesn't refer to
+func (ms *MemoryStorage) GetUnreferencedUploads(before time.Time) ([]*Upload, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	uploads := []*Upload{}
+	for _, stored := range ms.uploads {
+		if !stored.Created.Before(before) || ms.refersTo(stored.UserID, stored.Path) {
+			continue
+		}
+		upload := *stored
+		uploads = append(uploads, &upload)
+	}
+	return uploads, nil
+}
+
+//refersTo returns true if the profile of the user refers to the file at
+//path, caller must hold the lock
+func (ms *MemoryStorage) refersTo(uid, path string) bool {
+	content, ok := ms.contents[uid]
+	if !ok {
+		return false
+	}
+	for _, reference := range fileReferences(uid, content) {
+		if reference.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+//RemoveUpload forgets that the user uploaded the file at path
+func (ms *MemoryStorage) RemoveUpload(path, uid string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	delete(ms.uploads, path+"\x00"+uid)
+	return nil
+}
+
+//GetStorageUsed returns the total size of the files the user has uploaded
+func (ms *MemoryStorage) GetStorageUsed(uid string) (int64, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	var used int64
+	for _, upload := range ms.uploads {
+		if upload.UserID == uid {
+			used += upload.Size
+		}
+	}
+	return used, nil
+}
+
+//GetStorageQuota returns the quota the user has been given, or
+//ErrNoStorageQuota if the default applies
+func (ms *MemoryStorage) GetStorageQuota(uid string) (int64, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	quota, ok := ms.quotas[uid]
+	if !ok {
+		return 0, ErrNoStorageQuota
+	}
+	return quota, nil
+}
+
+//SetStorageQuota gives the user a quota of their own,
+//a negative quota returns them to the default
+func (ms *MemoryStorage) SetStorageQuota(uid string, quota int64) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if quota < 0 {
+		delete(ms.quotas, uid)
+	} else {
+		ms.quotas[uid] = quota
+	}
+	return nil
+}
+
+//CloseConnection does nothing since there is no connection to close
+func (ms *MemoryStorage) CloseConnection() {}
+
+//removeSession removes the session and the refresh tokens issued for it,
+//caller must hold the lock
+func (ms *MemoryStorage) removeSession(session *memorySession) {
+	delete(ms.sessions, session.SessionKey)
+	for hash, token := range ms.refresh {
+		if token.SessionID == session.ID {
+			delete(ms.refresh, hash)
+		}
+	}
+}
+
+//findUser returns the stored user matching email or user id, caller must hold the lock
+func (ms *MemoryStorage) findUser(user *User) *User {
+	if stored, ok := ms.users[strings.ToLower(user.Email)]; ok {
+		return stored
+	}
+	for _, stored := range ms.users {
+		if stored.UserID != "" && stored.UserID == user.UserID {
+			return stored
+		}
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	//ErrSchemaAhead if the database has been migrated by a newer version of the server
+	ErrSchemaAhead = errors.New("Database schema is newer than this server supports")
+
+	//ErrSchemaBehind if the database has migrations pending and applying them wasn't asked for
+	ErrSchemaBehind = errors.New("Database schema is older than this server needs")
+
+	//ErrUnknownMigration if asked to migrate to a version that does not exist
+	ErrUnknownMigration = errors.New("No such schema version")
+
+	//go:embed migrations
+	migrationFiles embed.FS
+
+	//migrationHooks holds data conversions that can't be expressed in sql
+	//shared by every dialect, keyed by the migration version they belong to
+	migrationHooks = map[int]migrationHook{
+		3: {Up: copyPDFsToTable, Down: copyPDFsToColumn},
+	}
+)
+
+//Migrator is implemented by storage backends with a versioned schema
+type Migrator interface {
+	SchemaVersion() (int, error)
+	LatestSchemaVersion() int
+	Migrate(target int) error
+}
+
+//Migration is a single step between two schema versions. Up takes the
+//schema from Version-1 to Version and Down reverts it
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+	Hook    migrationHook
+}
+
+//migrationHook converts data inside the migration transaction. Up runs
+//after the up statements and Down runs before the down statements
+type migrationHook struct {
+	Up   func(tx *sql.Tx) error
+	Down func(tx *sql.Tx) error
+}
+
+//loadMigrations reads the embedded migrations for a dialect. Files are named
+//<version>_<name>.up.sql and <version>_<name>.down.sql
+func loadMigrations(dir string) ([]*Migration, error) {
+	entries, err := migrationFiles.ReadDir(path.Join("migrations", dir))
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		parts := strings.SplitN(name, "_", 2)
+		if len(parts) != 2 || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid migration file name %s", name)
+		}
+		data, err := migrationFiles.ReadFile(path.Join("migrations", dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Hook: migrationHooks[version]}
+			byVersion[version] = migration
+		}
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			migration.Name = strings.TrimSuffix(parts[1], ".up.sql")
+			migration.Up = splitStatements(string(data))
+		case strings.HasSuffix(name, ".down.sql"):
+			migration.Down = splitStatements(string(data))
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("Migration %d is missing", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+//splitStatements splits a migration file into statements that
+//are ended by a semicolon at the end of a line
+func splitStatements(data string) []string {
+	var statements []string
+	for _, statement := range strings.Split(data, ";\n") {
+		statement = strings.TrimSpace(statement)
+		statement = strings.TrimSuffix(statement, ";")
+		if statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+//SchemaVersion returns the version the database schema is currently at
+func (dbi *DatabaseInterface) SchemaVersion() (int, error) {
+	_, err := dbi.DB.Exec("CREATE TABLE IF NOT EXISTS schema_version (version int NOT NULL PRIMARY KEY, applied_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP)")
+	if err != nil {
+		return 0, err
+	}
+	var version sql.NullInt64
+	err = dbi.DB.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+//LatestSchemaVersion returns the version this server was built for
+func (dbi *DatabaseInterface) LatestSchemaVersion() int {
+	return len(dbi.migrations)
+}
+
+//Migrate applies or reverts migrations until the schema is at target
+func (dbi *DatabaseInterface) Migrate(target int) error {
+	if target < 0 || target > len(dbi.migrations) {
+		return ErrUnknownMigration
+	}
+	current, err := dbi.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > len(dbi.migrations) {
+		return ErrSchemaAhead
+	}
+
+	for current < target {
+		migration := dbi.migrations[current]
+		err = dbi.runMigration(migration, true)
+		if err != nil {
+			return fmt.Errorf("Migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+		fmt.Printf("Migrated database to version %d (%s)\n", migration.Version, migration.Name)
+		current++
+	}
+	for current > target {
+		migration := dbi.migrations[current-1]
+		err = dbi.runMigration(migration, false)
+		if err != nil {
+			return fmt.Errorf("Reverting migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+		fmt.Printf("Reverted database to version %d\n", migration.Version-1)
+		current--
+	}
+	return nil
+}
+
+//runMigration executes a migration in either direction and records the
+//new version in one transaction. MySQL commits DDL implicitly, sqlite does not
+func (dbi *DatabaseInterface) runMigration(migration *Migration, up bool) error {
+	tx, err := dbi.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	statements, record := migration.Up, "INSERT INTO schema_version (version) VALUES (?)"
+	if !up {
+		statements, record = migration.Down, "DELETE FROM schema_version WHERE version=?"
+		if migration.Hook.Down != nil {
+			if err = migration.Hook.Down(tx); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if up && migration.Hook.Up != nil {
+		if err = migration.Hook.Up(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err = tx.Exec(record, migration.Version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+//copyPDFsToTable moves the json encoded PDFs column of UserContent
+//into one UserPDFs row per pdf
+func copyPDFsToTable(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT UserContent.UserId, UserContent.PDFs FROM UserContent JOIN Users ON Users.UserId = UserContent.UserId")
+	if err != nil {
+		return err
+	}
+	//Rows have to be read before inserting since the connection is busy until closed
+	pdfsByUser := make(map[string][]byte)
+	for rows.Next() {
+		var uid string
+		var jsonField []byte
+		if err = rows.Scan(&uid, &jsonField); err != nil {
+			rows.Close()
+			return err
+		}
+		pdfsByUser[uid] = jsonField
+	}
+	rows.Close()
+
+	for uid, jsonField := range pdfsByUser {
+		if len(jsonField) == 0 {
+			continue
+		}
+		//The column was often cut off for long portfolios, whatever was
+		//complete is kept rather than stopping the whole upgrade
+		pdfs, err := readPDFs(jsonField)
+		if err != nil {
+			fmt.Printf("PDFs of user %s could not be read, keeping the first %d: %v\n", uid, len(pdfs), err)
+		}
+		for i := range pdfs {
+			_, err = tx.Exec("INSERT INTO UserPDFs (UserId, Title, Path, Position) VALUES (?,?,?,?)", uid, pdfs[i].Title, pdfs[i].Path, i)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//readPDFs decodes the json encoded PDFs column one pdf at a time and
+//returns those that were read before any error
+func readPDFs(jsonField []byte) ([]PDF, error) {
+	pdfs := []PDF{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonField))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return pdfs, fmt.Errorf("Expected a list of pdfs, got %v (%v)", token, err)
+	}
+	for decoder.More() {
+		var pdf PDF
+		if err := decoder.Decode(&pdf); err != nil {
+			return pdfs, err
+		}
+		pdfs = append(pdfs, pdf)
+	}
+	_, err := decoder.Token()
+	return pdfs, err
+}
+
+//copyPDFsToColumn writes the UserPDFs rows back into the PDFs column as json
+func copyPDFsToColumn(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT UserId, Title, Path FROM UserPDFs ORDER BY UserId, Position")
+	if err != nil {
+		return err
+	}
+	pdfsByUser := make(map[string][]PDF)
+	for rows.Next() {
+		var uid string
+		var pdf PDF
+		if err = rows.Scan(&uid, &pdf.Title, &pdf.Path); err != nil {
+			rows.Close()
+			return err
+		}
+		pdfsByUser[uid] = append(pdfsByUser[uid], pdf)
+	}
+	rows.Close()
+
+	for uid, pdfs := range pdfsByUser {
+		jsonField, err := json.Marshal(pdfs)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec("UPDATE UserContent SET PDFs=? WHERE UserId=?", string(jsonField), uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,624 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	//How long the user has to log in at the provider
+	oidcFlowLifetime = time.Minute * 10
+
+	//Cookie that ties the flow to the browser that started it, so nobody
+	//can log a victim into their own account by sending them a callback link
+	oidcFlowCookie = "oidc_flow"
+
+	//Clock difference accepted between us and the provider
+	oidcClockSkew = time.Minute
+)
+
+var (
+	//ErrOIDCFlow if the state is unknown, expired or belongs to another browser
+	ErrOIDCFlow = errors.New("Login at the identity provider expired or was started elsewhere, please try again")
+
+	//ErrOIDCBusy if too many logins at the provider are in progress to start another
+	ErrOIDCBusy = errors.New("Too many logins at the identity provider in progress, please try again later")
+
+	//oidcMaxFlows is how many logins at the provider can be in progress at
+	//once, so that starting logins without finishing them can't fill the memory
+	oidcMaxFlows = 10000
+
+	//ErrOIDCUnverifiedEmail if the provider can't vouch for the email of a new user
+	ErrOIDCUnverifiedEmail = errors.New("The identity provider has not verified your email")
+
+	//oidcProvider is nil unless .oidc_cnf configures single sign-on
+	oidcProvider *OIDCProvider
+)
+
+//OIDCProvider is the OpenID Connect provider users can log in with.
+//The endpoints and keys of the provider are discovered from the issuer.
+//It is safe for concurrent use
+type OIDCProvider struct {
+	Name         string //Shown on the login button
+	Issuer       string
+	ClientID     string
+	ClientSecret string //Empty for public clients, which rely on PKCE alone
+	RedirectURL  string
+	Scopes       []string
+	Client       *http.Client
+
+	mutex       sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]JSONWebKey
+	keysFetched time.Time
+	flows       map[string]*oidcFlow //Keyed by state
+}
+
+//oidcDiscovery is the part of the provider metadata we use
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+//oidcFlow is a login that has been sent to the provider
+type oidcFlow struct {
+	Verifier string //PKCE code verifier
+	Nonce    string
+	Created  time.Time
+}
+
+//OIDCIdentity is what a validated ID token says about the user
+type OIDCIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+//OIDCRequest is sent by the client when the provider has redirected back
+type OIDCRequest struct {
+	Code  string
+	State string
+}
+
+//OIDCProviderResponse tells the client which provider it can log in with
+type OIDCProviderResponse struct {
+	Name string
+}
+
+//NewOIDCProvider returns a provider that discovers its endpoints the first time it is used
+func NewOIDCProvider(name, issuer, clientID, clientSecret, redirectURL string) *OIDCProvider {
+	return &OIDCProvider{
+		Name:         name,
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email"},
+		Client:       &http.Client{Timeout: time.Second * 10},
+		flows:        make(map[string]*oidcFlow),
+	}
+}
+
+//setupOIDC reads the provider from .oidc_cnf if present:
+//issuer, client_id, client_secret (optional) and name (optional)
+func setupOIDC() {
+	file, err := os.Open(".oidc_cnf")
+	if err != nil {
+		return
+	}
+	cnf := readConfigurations(file)
+	file.Close()
+
+	if cnf["ISSUER"] == "" || cnf["CLIENT_ID"] == "" {
+		fmt.Println(".oidc_cnf needs an issuer and a client_id, single sign-on is off")
+		return
+	}
+	name := cnf["NAME"]
+	if name == "" {
+		name = cnf["ISSUER"]
+	}
+	redirect := strings.TrimSuffix(*publicURL, "/") + "/api/oidc/callback"
+	oidcProvider = NewOIDCProvider(name, cnf["ISSUER"], cnf["CLIENT_ID"], cnf["CLIENT_SECRET"], redirect)
+}
+
+//AuthorizationURL starts a login and returns where to send the user
+//together with the state identifying the login
+func (provider *OIDCProvider) AuthorizationURL() (string, string, error) {
+	discovery, err := provider.discover()
+	if err != nil {
+		return "", "", err
+	}
+	state := randBase64String(32)
+	flow := &oidcFlow{Verifier: randBase64String(48), Nonce: randBase64String(32), Created: time.Now()}
+
+	provider.mutex.Lock()
+	for key, old := range provider.flows {
+		if time.Since(old.Created) > oidcFlowLifetime {
+			delete(provider.flows, key)
+		}
+	}
+	if len(provider.flows) >= oidcMaxFlows {
+		provider.mutex.Unlock()
+		return "", "", ErrOIDCBusy
+	}
+	provider.flows[state] = flow
+	provider.mutex.Unlock()
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.ClientID},
+		"redirect_uri":          {provider.RedirectURL},
+		"scope":                 {strings.Join(provider.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {flow.Nonce},
+		"code_challenge":        {pkceChallenge(flow.Verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), state, nil
+}
+
+//Exchange trades the code the provider redirected back with for an ID
+//token and returns who it identifies. Every state can be exchanged once
+func (provider *OIDCProvider) Exchange(code, state string) (*OIDCIdentity, error) {
+	provider.mutex.Lock()
+	flow, ok := provider.flows[state]
+	delete(provider.flows, state)
+	provider.mutex.Unlock()
+	if !ok || time.Since(flow.Created) > oidcFlowLifetime {
+		return nil, ErrOIDCFlow
+	}
+
+	discovery, err := provider.discover()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {provider.RedirectURL},
+		"client_id":     {provider.ClientID},
+		"code_verifier": {flow.Verifier},
+	}
+	request, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if provider.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(provider.ClientID), url.QueryEscape(provider.ClientSecret))
+	}
+	response, err := provider.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("Unreadable token response from identity provider: %v", err)
+	}
+	if response.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("Identity provider refused the code: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+	return provider.verifyIDToken(tokens.IDToken, flow.Nonce)
+}
+
+//verifyIDToken checks the signature and claims of an ID token as
+//described in OpenID Connect Core 3.1.3.7
+func (provider *OIDCProvider) verifyIDToken(raw, nonce string) (*OIDCIdentity, error) {
+	token, err := jwt.Parse(raw, provider.verifyKey)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ID token: %v", err)
+	}
+	claims := token.Claims
+
+	now := time.Now()
+	if iss, _ := claims["iss"].(string); iss != provider.Issuer {
+		return nil, fmt.Errorf("ID token was issued by %s", iss)
+	}
+	if !audienceContains(claims["aud"], provider.ClientID) {
+		return nil, errors.New("ID token is meant for another client")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != provider.ClientID {
+		return nil, errors.New("ID token is meant for another client")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.Add(-oidcClockSkew).Unix() >= int64(exp) {
+		return nil, errors.New("ID token has expired")
+	}
+	if iat, ok := claims["iat"].(float64); !ok || int64(iat) > now.Add(oidcClockSkew).Unix() {
+		return nil, errors.New("ID token is issued in the future")
+	}
+	if got, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return nil, errors.New("ID token nonce doesn't match")
+	}
+
+	identity := new(OIDCIdentity)
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	//Some providers send the boolean as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	if identity.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	return identity, nil
+}
+
+//verifyKey returns the published key of the provider the token is signed
+//with. Only asymmetric algorithms are accepted, the client secret is
+//never used to verify tokens
+func (provider *OIDCProvider) verifyKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	jwk, err := provider.key(kid)
+	if err != nil {
+		return nil, err
+	}
+	if jwk.Algorithm != "" && jwk.Algorithm != token.Method.Alg() {
+		return nil, fmt.Errorf("Key %s is not used with %s", kid, token.Method.Alg())
+	}
+	public, err := jwk.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches bool
+	switch public.(type) {
+	case *rsa.PublicKey:
+		_, matches = token.Method.(*jwt.SigningMethodRSA)
+	case *ecdsa.PublicKey:
+		matches = token.Method.Alg() == "ES256"
+	case ed25519.PublicKey:
+		_, matches = token.Method.(signingMethodEdDSA)
+	}
+	if !matches {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+	return public, nil
+}
+
+//key returns the published key with the id. The keys are fetched again
+//when the id is unknown since the provider might have rotated its keys
+func (provider *OIDCProvider) key(kid string) (JSONWebKey, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	jwk, ok := provider.findKey(kid)
+	if !ok && time.Since(provider.keysFetched) > keyReloadInterval {
+		if err := provider.fetchKeys(); err != nil {
+			return jwk, err
+		}
+		jwk, ok = provider.findKey(kid)
+	}
+	if !ok {
+		return jwk, ErrUnknownKey
+	}
+	return jwk, nil
+}
+
+//findKey looks up a key by id. Tokens without an id are accepted if the
+//provider publishes a single key. Caller must hold the lock
+func (provider *OIDCProvider) findKey(kid string) (JSONWebKey, bool) {
+	if kid == "" && len(provider.keys) == 1 {
+		for _, jwk := range provider.keys {
+			return jwk, true
+		}
+	}
+	jwk, ok := provider.keys[kid]
+	return jwk, ok
+}
+
+//fetchKeys downloads the published keys of the provider. Caller must hold the lock
+func (provider *OIDCProvider) fetchKeys() error {
+	discovery, err := provider.discoverLocked()
+	if err != nil {
+		return err
+	}
+	var jwks struct {
+		Keys []JSONWebKey `json:"keys"`
+	}
+	if err = provider.getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return err
+	}
+	provider.keys = make(map[string]JSONWebKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use == "" || jwk.Use == "sig" {
+			provider.keys[jwk.ID] = jwk
+		}
+	}
+	provider.keysFetched = time.Now()
+	return nil
+}
+
+//discover returns the provider metadata, fetched on first use
+func (provider *OIDCProvider) discover() (*oidcDiscovery, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	return provider.discoverLocked()
+}
+
+//discoverLocked is discover for callers holding the lock
+func (provider *OIDCProvider) discoverLocked() (*oidcDiscovery, error) {
+	if provider.discovery != nil {
+		return provider.discovery, nil
+	}
+	discovery := new(oidcDiscovery)
+	if err := provider.getJSON(provider.Issuer+"/.well-known/openid-configuration", discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != provider.Issuer {
+		return nil, fmt.Errorf("Identity provider calls itself %s, expected %s", discovery.Issuer, provider.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("Identity provider metadata is missing endpoints")
+	}
+	provider.discovery = discovery
+	return discovery, nil
+}
+
+//getJSON decodes the json document at the address
+func (provider *OIDCProvider) getJSON(address string, value interface{}) error {
+	response, err := provider.Client.Get(address)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Identity provider answered %s for %s", response.Status, address)
+	}
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+//pkceChallenge returns the S256 code challenge of the verifier (RFC 7636)
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return jwt.EncodeSegment(sum[:])
+}
+
+//audienceContains checks the aud claim, which is either a string or a list
+func audienceContains(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, entry := range aud {
+			if entry == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//userForIdentity returns the user linked to the account at the provider.
+//On first login the account is linked to the user with the same email,
+//or a new user is created if the registration policy allows, as long as
+//the provider has verified the email. A user who never verified the email
+//is reclaimed for its owner before being linked
+func userForIdentity(provider *OIDCProvider, identity *OIDCIdentity) (*User, error) {
+	linked, err := db.GetExternalIdentity(provider.Issuer, identity.Subject)
+	if err == nil {
+		return db.LookupUser(&User{UserID: linked.UserID})
+	}
+	if err != ErrNoExternalIdentity {
+		return nil, err
+	}
+	if identity.Email == "" || !identity.EmailVerified || validateEmail(identity.Email) != nil {
+		return nil, ErrOIDCUnverifiedEmail
+	}
+
+	user, err := db.LookupUser(&User{Email: identity.Email})
+	if err == ErrNoUserFound {
+		//Nobody knows the password, it can be set with a password reset
+		user = &User{Email: identity.Email, EmailVerified: true}
+		if err = checkRegistration(user); err != nil {
+			return nil, err
+		}
+		newUserIdentifiers(user)
+		user.Password = hashPassword(randBase64String(32), user.Salt)
+		err = createUser(user)
+	} else if err == nil && !user.EmailVerified {
+		err = reclaimAccount(user)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.AddExternalIdentity(&ExternalIdentity{
+		Issuer:  provider.Issuer,
+		Subject: identity.Subject,
+		UserID:  user.UserID,
+		Email:   identity.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+//reclaimAccount hands an account whose email was never verified to the
+//owner of the email, as vouched for by the provider. Whoever registered it
+//may not be them, so everything that let them in is taken away: the
+//password is replaced, and sessions, api keys and two-factor are removed
+func reclaimAccount(user *User) error {
+	for {
+		user.Salt = randBase64String(128)
+		if db.UniqueIdentifier(user.Salt) {
+			break
+		}
+	}
+	user.Password = hashPassword(randBase64String(32), user.Salt)
+	err := db.UpdatePassword(user)
+	if err == nil {
+		err = db.RemoveUserSessions(user.UserID)
+	}
+	if err == nil {
+		err = db.RemoveTwoFactor(user.UserID)
+	}
+	for _, purpose := range []string{TokenPurposePasswordReset, TokenPurposeTwoFactor} {
+		if err == nil {
+			err = db.RemoveOneTimeTokens(user.UserID, purpose)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	keys, err := db.GetAPIKeys(user.UserID)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = db.RemoveAPIKey(user.UserID, key.ID); err != nil {
+			return err
+		}
+	}
+	if err = db.SetEmailVerified(user.UserID); err != nil {
+		return err
+	}
+	user.EmailVerified = true
+	return nil
+}
+
+//Tells the client if single sign-on is available and what to call it
+func getOIDCProvider(w http.ResponseWriter, r *http.Request) {
+	if oidcProvider == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Single sign-on is not configured"))
+		return
+	}
+	writeJSON(w, OIDCProviderResponse{Name: oidcProvider.Name})
+}
+
+//Sends the browser to the identity provider to log in
+func oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if oidcProvider == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Single sign-on is not configured"))
+		return
+	}
+	address, state, err := oidcProvider.AuthorizationURL()
+	if err == ErrOIDCBusy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("Unable to reach the identity provider"))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    state,
+		Path:     "/api/oidc/",
+		MaxAge:   int(oidcFlowLifetime / time.Second),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(*publicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, address, http.StatusFound)
+}
+
+//The identity provider redirects the browser here after login. The code is
+//handed to the web client which exchanges it at /api/oidc/token, since that
+//is where it stores its tokens
+func oidcCallback(w http.ResponseWriter, r *http.Request) {
+	query := url.Values{}
+	for _, name := range []string{"code", "state", "error", "error_description"} {
+		if value := r.URL.Query().Get(name); value != "" {
+			query.Set(name, value)
+		}
+	}
+	http.Redirect(w, r, "/#/oidc/callback?"+query.Encode(), http.StatusFound)
+}
+
+//Exchanges the code from the identity provider and logs the user in
+func oidcToken(w http.ResponseWriter, r *http.Request) {
+	if !usingDatabase(w) {
+		return
+	}
+	if oidcProvider == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Single sign-on is not configured"))
+		return
+	}
+	request := new(OIDCRequest)
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil || request.Code == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Code and state are required"))
+		return
+	}
+	cookie, err := r.Cookie(oidcFlowCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(request.State)) != 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(ErrOIDCFlow.Error()))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcFlowCookie, Path: "/api/oidc/", MaxAge: -1})
+
+	identity, err := oidcProvider.Exchange(request.Code, request.State)
+	if err == ErrOIDCFlow {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Login at the identity provider failed"))
+		return
+	}
+
+	user, err := userForIdentity(oidcProvider, identity)
+	if err == ErrOIDCUnverifiedEmail || err == ErrRegistrationClosed || err == ErrInviteRequired || err == ErrEmailDomain || err == ErrNoInviteCode {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to log in"))
+		return
+	}
+	//The provider vouches for the email, not for the second factor of an
+	//account that was linked to it, so that is asked for as by login
+	if !allowedUnverified(w, user, RestrictLogin) {
+		return
+	}
+	if requireSecondFactor(w, user) {
+		return
+	}
+	writeNewToken(w, r, user)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gebi/scryptauth"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	//PasswordScrypt hashes are stored the scryptauth way: <N>:<hash>:<salt>
+	PasswordScrypt = "scrypt"
+
+	//PasswordArgon2id hashes are stored in the PHC string format:
+	//$argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+	PasswordArgon2id = "argon2id"
+
+	//scrypt r and p have never been part of the stored hash so they can't change
+	scryptBlockSize   = 8
+	scryptParallelism = 1
+	scryptKeyLength   = 128
+
+	argon2KeyLength = 32
+	argon2Threads   = 1
+)
+
+var (
+	//ErrUnknownPasswordHash if a stored password hash can't be parsed
+	ErrUnknownPasswordHash = errors.New("Unknown password hash format")
+
+	//ErrPasswordCost if the configured scrypt cost is not a power of two above 1
+	ErrPasswordCost = errors.New("Password cost must be a power of two larger than 1")
+
+	//New hashes are computed with these, stored hashes using other
+	//parameters are upgraded when their user logs in
+	passwordAlgorithm = PasswordScrypt
+	argon2Memory      = uint32(64 * 1024) //KiB
+	argon2Time        = uint32(3)
+
+	//Since the code will be run by a raspberry pi, 4096 is the best
+	//we can do when it comes to cost for our key. Should be increased
+	//to 1048576 (1 << 20) when migrating to a more high end system.
+	passwordCost = 1 << 12
+
+	//unknownUserSalt is hashed with when someone tries to log in as a
+	//user that doesn't exist, it looks like the salt of a real user
+	unknownUserSalt = randBase64String(128)
+)
+
+//passwordHash is a decoded stored password hash together with
+//the parameters it was computed with
+type passwordHash struct {
+	Algorithm string
+	Cost      int //scrypt N
+	Memory    uint32
+	Time      uint32
+	Threads   uint8
+	Salt      []byte
+	Hash      []byte
+}
+
+//setPasswordHashing changes how new password hashes are computed.
+//Existing hashes keep working and are upgraded when their user logs in
+func setPasswordHashing(algorithm string, cost int, memory, time uint) error {
+	switch algorithm {
+	case PasswordScrypt:
+		if cost < 2 || cost&(cost-1) != 0 {
+			return ErrPasswordCost
+		}
+	case PasswordArgon2id:
+		if memory < 8*argon2Threads || time < 1 {
+			return errors.New("Argon2id needs at least 8 KiB of memory and one pass")
+		}
+	default:
+		return fmt.Errorf("Unsupported password hash %s, use scrypt or argon2id", algorithm)
+	}
+	passwordAlgorithm = algorithm
+	passwordCost = cost
+	argon2Memory = uint32(memory)
+	argon2Time = uint32(time)
+	return nil
+}
+
+//hashPassword generates the KDF of the password and salt with the
+//current parameters, encoded the way it is stored in the database
+func hashPassword(password, salt string) string {
+	hash := &passwordHash{Algorithm: passwordAlgorithm, Salt: []byte(salt)}
+	switch passwordAlgorithm {
+	case PasswordArgon2id:
+		hash.Memory, hash.Time, hash.Threads = argon2Memory, argon2Time, argon2Threads
+	default:
+		hash.Cost = passwordCost
+	}
+	hash.Hash, _ = hash.compute(password)
+	return hash.String()
+}
+
+//authenticatePassword checks the password against the stored hash of
+//the user. Hashes computed with outdated parameters are replaced by one
+//using the current parameters, since the password is known right now
+func authenticatePassword(user *User, password string) bool {
+	stored, err := parsePasswordHash(user.Password)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	computed, err := stored.compute(password)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	if subtle.ConstantTimeCompare(computed, stored.Hash) != 1 {
+		return false
+	}
+
+	if stored.outdated() {
+		user.Password = hashPassword(password, user.Salt)
+		if err = db.UpdatePassword(user); err != nil {
+			fmt.Println("Unable to upgrade password hash: " + err.Error())
+		}
+	}
+	return true
+}
+
+//parsePasswordHash decodes a stored hash of either format
+func parsePasswordHash(encoded string) (*passwordHash, error) {
+	if strings.HasPrefix(encoded, "$"+PasswordArgon2id+"$") {
+		return parseArgon2id(encoded)
+	}
+
+	cost, hash, salt, err := scryptauth.DecodeBase64([]byte(encoded))
+	if err != nil || cost < 2 || cost&(cost-1) != 0 {
+		return nil, ErrUnknownPasswordHash
+	}
+	return &passwordHash{Algorithm: PasswordScrypt, Cost: int(cost), Salt: salt, Hash: hash}, nil
+}
+
+//parseArgon2id decodes a hash in the PHC string format
+func parseArgon2id(encoded string) (*passwordHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return nil, ErrUnknownPasswordHash
+	}
+	hash := &passwordHash{Algorithm: PasswordArgon2id}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &hash.Memory, &hash.Time, &hash.Threads)
+	if err != nil || hash.Time < 1 || hash.Threads < 1 {
+		return nil, ErrUnknownPasswordHash
+	}
+	if hash.Salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownPasswordHash
+	}
+	if hash.Hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, ErrUnknownPasswordHash
+	}
+	return hash, nil
+}
+
+//compute derives the key of the password using the parameters of the hash
+func (hash *passwordHash) compute(password string) ([]byte, error) {
+	if hash.Algorithm == PasswordArgon2id {
+		return argon2.IDKey([]byte(password), hash.Salt, hash.Time, hash.Memory, hash.Threads, argon2KeyLength), nil
+	}
+	return scrypt.Key([]byte(password), hash.Salt, hash.Cost, scryptBlockSize, scryptParallelism, scryptKeyLength)
+}
+
+//outdated reports if the hash was computed with other parameters than
+//new hashes are
+func (hash *passwordHash) outdated() bool {
+	if hash.Algorithm != passwordAlgorithm {
+		return true
+	}
+	if hash.Algorithm == PasswordArgon2id {
+		return hash.Memory != argon2Memory || hash.Time != argon2Time || hash.Threads != argon2Threads
+	}
+	return hash.Cost != passwordCost
+}
+
+//String encodes the hash the way it is stored in the database
+func (hash *passwordHash) String() string {
+	if hash.Algorithm == PasswordArgon2id {
+		return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", PasswordArgon2id, argon2.Version,
+			hash.Memory, hash.Time, hash.Threads,
+			base64.RawStdEncoding.EncodeToString(hash.Salt),
+			base64.RawStdEncoding.EncodeToString(hash.Hash))
+	}
+	return string(scryptauth.EncodeBase64(uint(hash.Cost), hash.Hash, hash.Salt))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+//Reset links are only valid for a short while since anyone with
+//access to the mailbox can use them
+const passwordResetLifetime = time.Hour
+
+//PasswordResetRequest is sent by the client to request or perform a password reset
+type PasswordResetRequest struct {
+	Email    string
+	Token    string
+	Password string
+}
+
+//Mails a password reset link to the user. The response is the same whether
+//the email is registered or not so nobody can find out who is registered
+func forgotPassword(w http.ResponseWriter, r *http.Request) {
+	if !usingDatabase(w) {
+		return
+	}
+
+	request, err := readPasswordResetRequest(r)
+	if err != nil || validateEmail(request.Email) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid email"))
+		return
+	}
+
+	user, err := db.LookupUser(&User{Email: request.Email})
+	if err == nil {
+		token, err := issueOneTimeToken(user, TokenPurposePasswordReset, passwordResetLifetime)
+		if err == nil {
+			err = sendMail(user.Email, "password-reset", MailLink{*publicURL + "/#/password/reset/" + token})
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("If the email is registered a reset link has been sent to it"))
+}
+
+//Sets a new password for the user the reset token was issued to, logs the
+//user out of every session and makes every other reset link useless
+func resetPassword(w http.ResponseWriter, r *http.Request) {
+	if !usingDatabase(w) {
+		return
+	}
+
+	request, err := readPasswordResetRequest(r)
+	if err != nil || request.Token == "" || request.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Token and new password are required"))
+		return
+	}
+
+	token, err := db.UseOneTimeToken(hashToken(request.Token), TokenPurposePasswordReset)
+	if err != nil || !token.ExpiresAt.After(time.Now()) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid or expired reset link"))
+		return
+	}
+
+	user := &User{UserID: token.UserID}
+	for {
+		user.Salt = randBase64String(128)
+		if db.UniqueIdentifier(user.Salt) {
+			break
+		}
+	}
+	user.Password = hashPassword(request.Password, user.Salt)
+	//An older reset mail must not be able to undo the new password
+	err = db.RemoveOneTimeTokens(user.UserID, TokenPurposePasswordReset)
+	if err == nil {
+		err = db.UpdatePassword(user)
+	}
+	if err == nil {
+		err = db.RemoveUserSessions(user.UserID)
+	}
+	if err == nil {
+		//The reset link was mailed to the user so the address is proven to be theirs
+		err = db.SetEmailVerified(user.UserID)
+	}
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to reset password"))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("Password has been reset, please log in"))
+}
+
+//issueOneTimeToken creates a token for the purpose that expires after lifetime.
+//Only its hash is stored, the token itself is returned to be mailed to the user
+func issueOneTimeToken(user *User, purpose string, lifetime time.Duration) (string, error) {
+	token := randBase64String(32)
+	now := time.Now()
+	err := db.InsertOneTimeToken(&OneTimeToken{
+		Hash:      hashToken(token),
+		UserID:    user.UserID,
+		Purpose:   purpose,
+		Created:   now,
+		ExpiresAt: now.Add(lifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+//readPasswordResetRequest parses the json body of the request
+func readPasswordResetRequest(r *http.Request) (*PasswordResetRequest, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	request := new(PasswordResetRequest)
+	err = json.Unmarshal(body, request)
+	return request, err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//Room a multipart body needs besides the file itself, for the
+//boundaries and headers of the parts
+const multipartOverhead = 64 << 10
+
+var (
+	//ErrFileTooLarge if an upload is larger than allowed for its kind
+	ErrFileTooLarge = errors.New("File is too large")
+
+	//ErrQuotaExceeded if an upload doesn't fit in what is left of the storage quota
+	ErrQuotaExceeded = errors.New("Storage quota exceeded")
+
+	//defaultQuota is how much every user may upload in total,
+	//unless they have been given a quota of their own
+	defaultQuota = ByteSize(50 << 20)
+
+	//maxUploadSizes is the largest file every kind of upload may be
+	maxUploadSizes = map[string]*ByteSize{
+		FileKindPDF:           newByteSize(10 << 20),
+		FileKindProfileHeader: newByteSize(5 << 20),
+		FileKindProfileIcon:   newByteSize(2 << 20),
+	}
+
+	byteUnits = []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+)
+
+func init() {
+	flag.Var(&defaultQuota, "quota", "How much every user may upload in total, e.g. 50MB")
+	flag.Var(maxUploadSizes[FileKindPDF], "maxpdf", "Largest pdf that can be uploaded, e.g. 10MB")
+	flag.Var(maxUploadSizes[FileKindProfileHeader], "maxheader", "Largest profile header image that can be uploaded, e.g. 5MB")
+	flag.Var(maxUploadSizes[FileKindProfileIcon], "maxicon", "Largest profile icon that can be uploaded, e.g. 2MB")
+}
+
+//ByteSize is a number of bytes written with a unit, such as 10MB.
+//Units are powers of 1024
+type ByteSize int64
+
+func newByteSize(size int64) *ByteSize {
+	byteSize := ByteSize(size)
+	return &byteSize
+}
+
+//String writes the size in the largest unit it has at least one of
+func (size ByteSize) String() string {
+	for _, unit := range byteUnits {
+		if int64(size) >= unit.size || unit.size == 1 {
+			if int64(size)%unit.size == 0 {
+				return strconv.FormatInt(int64(size)/unit.size, 10) + unit.suffix
+			}
+			return strconv.FormatFloat(float64(size)/float64(unit.size), 'f', 1, 64) + unit.suffix
+		}
+	}
+	return ""
+}
+
+//Set reads a size such as 512KB, 1.5GB or a plain number of bytes
+func (size *ByteSize) Set(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, multiplier = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix)), unit.size
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return fmt.Errorf("Invalid size %s, use for example 512KB or 10MB", value)
+	}
+	*size = ByteSize(number * float64(multiplier))
+	return nil
+}
+
+//StorageUsage tells users how much they have uploaded and may upload
+type StorageUsage struct {
+	Used  int64
+	Quota int64
+}
+
+//storageUsage returns how much the user has uploaded and the quota that applies
+func storageUsage(uid string) (*StorageUsage, error) {
+	used, err := db.GetStorageUsed(uid)
+	if err != nil {
+		return nil, err
+	}
+	quota, err := db.GetStorageQuota(uid)
+	if err == ErrNoStorageQuota {
+		quota, err = int64(defaultQuota), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &StorageUsage{Used: used, Quota: quota}, nil
+}
+
+//available returns how much more can be uploaded before the quota is reached
+func (usage *StorageUsage) available() int64 {
+	if usage.Used >= usage.Quota {
+		return 0
+	}
+	return usage.Quota - usage.Used
+}
+
+//tooLargeMessage tells the client how large an upload of the kind may be
+func tooLargeMessage(kind string) string {
+	return "File is too large, " + kind + " uploads can be at most " + maxUploadSizes[kind].String()
+}
+
+//quotaExceededMessage tells the client how much of their quota they have used
+func quotaExceededMessage(usage *StorageUsage) string {
+	return "Not enough storage left, " + ByteSize(usage.Used).String() + " of " + ByteSize(usage.Quota).String() + " used"
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//RateByIP gives every address its own bucket
+	RateByIP = "ip"
+
+	//RateByUser gives every logged in user its own bucket,
+	//requests without a valid token fall back to their address
+	RateByUser = "user"
+)
+
+//rateLimiters holds a limiter for every throttled route, keyed by a short
+//name of the route. These are the defaults, .ratelimit_cnf overrides them
+var rateLimiters = map[string]*RateLimiter{
+	"register":        NewRateLimiter("register", RateLimit{Requests: 5, Per: time.Hour, Burst: 5, Key: RateByIP}),
+	"login":           NewRateLimiter("login", RateLimit{Requests: 30, Per: time.Minute, Burst: 10, Key: RateByIP}),
+	"login-2fa":       NewRateLimiter("login-2fa", RateLimit{Requests: 10, Per: time.Minute, Burst: 5, Key: RateByIP}),
+	"oidc-login":      NewRateLimiter("oidc-login", RateLimit{Requests: 30, Per: time.Minute, Burst: 10, Key: RateByIP}),
+	"password-forgot": NewRateLimiter("password-forgot", RateLimit{Requests: 5, Per: time.Hour, Burst: 3, Key: RateByIP}),
+	"email-resend":    NewRateLimiter("email-resend", RateLimit{Requests: 5, Per: time.Hour, Burst: 3, Key: RateByUser}),
+	"upload":          NewRateLimiter("upload", RateLimit{Requests: 30, Per: time.Hour, Burst: 10, Key: RateByUser}),
+	"get-view":        NewRateLimiter("get-view", RateLimit{Requests: 120, Per: time.Minute, Burst: 60, Key: RateByIP}),
+}
+
+//RateLimit is how many requests a key may make per period. Burst is
+//how many it may make at once after having been quiet for a while
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+	Key      string //RateByIP or RateByUser
+	Disabled bool
+}
+
+//RateCounters tells how a rate limiter has been doing since the server started
+type RateCounters struct {
+	Name    string
+	Limit   RateLimit
+	Allowed uint64
+	Limited uint64
+	Buckets int //Keys that have made requests recently
+}
+
+//RateLimiter throttles requests with a token bucket per key. Every
+//request takes a token and tokens are added back at the rate of the
+//limit, up to the burst. It is safe for concurrent use
+type RateLimiter struct {
+	name    string
+	mutex   sync.Mutex
+	limit   RateLimit
+	buckets map[string]*tokenBucket
+	allowed uint64
+	limited uint64
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+//NewRateLimiter returns a limiter without any buckets
+func NewRateLimiter(name string, limit RateLimit) *RateLimiter {
+	return &RateLimiter{name: name, limit: limit, buckets: make(map[string]*tokenBucket)}
+}
+
+//SetLimit replaces the limit, buckets are emptied since they were filled at the old rate
+func (limiter *RateLimiter) SetLimit(limit RateLimit) {
+	limiter.mutex.Lock()
+	limiter.limit = limit
+	limiter.buckets = make(map[string]*tokenBucket)
+	limiter.mutex.Unlock()
+}
+
+//Allow takes a token from the bucket of the key. If it is empty false is
+//returned together with how long it takes until the next token is added
+func (limiter *RateLimiter) Allow(key string) (bool, time.Duration) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if limiter.limit.Disabled {
+		limiter.allowed++
+		return true, 0
+	}
+	now := time.Now()
+	rate := limiter.rate()
+	bucket, ok := limiter.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limiter.limit.Burst), updated: now}
+		limiter.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(float64(limiter.limit.Burst), bucket.tokens+now.Sub(bucket.updated).Seconds()*rate)
+	bucket.updated = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		limiter.allowed++
+		return true, 0
+	}
+	limiter.limited++
+	return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+}
+
+//rate returns how many tokens are added per second
+func (limiter *RateLimiter) rate() float64 {
+	return float64(limiter.limit.Requests) / limiter.limit.Per.Seconds()
+}
+
+//Counters returns how many requests have been allowed and limited
+func (limiter *RateLimiter) Counters() RateCounters {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	return RateCounters{
+		Name:    limiter.name,
+		Limit:   limiter.limit,
+		Allowed: limiter.allowed,
+		Limited: limiter.limited,
+		Buckets: len(limiter.buckets),
+	}
+}
+
+//Prune forgets buckets that have filled up again, they
+//behave exactly like a bucket that was never used
+func (limiter *RateLimiter) Prune() {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+	rate := limiter.rate()
+	for key, bucket := range limiter.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*rate >= float64(limiter.limit.Burst) {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+//Limit wraps a handler so that requests over the limit are
+//answered with 429 Too Many Requests instead
+func (limiter *RateLimiter) Limit(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limiter.mutex.Lock()
+		byUser := limiter.limit.Key == RateByUser
+		limiter.mutex.Unlock()
+
+		key := addressKey(clientIP(r))
+		if byUser {
+			if uid, ok := tokenUserID(r); ok {
+				key = "user:" + uid
+			}
+		}
+		if ok, wait := limiter.Allow(key); !ok {
+			seconds := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+			w.Header().Set("Retry-After", seconds)
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("Too many requests, try again in " + seconds + " seconds"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+//rateLimited wraps the handler with the limiter of the named route
+func rateLimited(name string, handler http.HandlerFunc) http.HandlerFunc {
+	limiter, ok := rateLimiters[name]
+	if !ok {
+		panic("No rate limit named " + name)
+	}
+	return limiter.Limit(handler)
+}
+
+//pruneRateLimiters forgets the full buckets of every limiter
+func pruneRateLimiters() {
+	for _, limiter := range rateLimiters {
+		limiter.Prune()
+	}
+}
+
+//rateCounters returns the counters of every limiter sorted by name
+func rateCounters() []RateCounters {
+	counters := make([]RateCounters, 0, len(rateLimiters))
+	for _, limiter := range rateLimiters {
+		counters = append(counters, limiter.Counters())
+	}
+	sort.Slice(counters, func(i, j int) bool {
+		return counters[i].Name < counters[j].Name
+	})
+	return counters
+}
+
+//loadRateLimits reads .ratelimit_cnf if present. Every line holds the name
+//of a route and its limit as <requests>/<s|m|h> [burst] [ip|user], or off
+func loadRateLimits() {
+	file, err := os.Open(".ratelimit_cnf")
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	for name, value := range readConfigurations(file) {
+		limiter, ok := rateLimiters[strings.ToLower(name)]
+		if !ok {
+			fmt.Println("Unknown route in .ratelimit_cnf: " + name)
+			continue
+		}
+		limit, err := parseRateLimit(value, limiter.Counters().Limit)
+		if err != nil {
+			fmt.Println("Invalid rate limit for " + name + ": " + err.Error())
+			continue
+		}
+		limiter.SetLimit(limit)
+	}
+}
+
+//parseRateLimit reads a limit as written in .ratelimit_cnf. Burst and key
+//are taken from the previous limit when left out
+func parseRateLimit(value string, previous RateLimit) (RateLimit, error) {
+	fields := strings.Fields(strings.ToLower(value))
+	if len(fields) == 1 && fields[0] == "off" {
+		previous.Disabled = true
+		return previous, nil
+	}
+	if len(fields) == 0 || len(fields) > 3 {
+		return previous, fmt.Errorf("expected <requests>/<s|m|h> [burst] [ip|user]")
+	}
+
+	limit := RateLimit{Burst: previous.Burst, Key: previous.Key}
+	rate := strings.SplitN(fields[0], "/", 2)
+	requests, err := strconv.Atoi(rate[0])
+	if err != nil || requests < 1 || len(rate) != 2 {
+		return previous, fmt.Errorf("expected <requests>/<s|m|h>, got %s", fields[0])
+	}
+	limit.Requests = requests
+	switch rate[1] {
+	case "s":
+		limit.Per = time.Second
+	case "m":
+		limit.Per = time.Minute
+	case "h":
+		limit.Per = time.Hour
+	default:
+		return previous, fmt.Errorf("unknown period %s, use s, m or h", rate[1])
+	}
+
+	for _, field := range fields[1:] {
+		switch field {
+		case RateByIP, RateByUser:
+			limit.Key = field
+		default:
+			burst, err := strconv.Atoi(field)
+			if err != nil || burst < 1 {
+				return previous, fmt.Errorf("burst must be a positive number, got %s", field)
+			}
+			limit.Burst = burst
+		}
+	}
+	return limit, nil
+}
+
+//String writes the limit the way it is configured
+func (limit RateLimit) String() string {
+	if limit.Disabled {
+		return "off"
+	}
+	period := map[time.Duration]string{time.Second: "s", time.Minute: "m", time.Hour: "h"}[limit.Per]
+	if period == "" {
+		period = limit.Per.String()
+	}
+	return fmt.Sprintf("%d/%s %d %s", limit.Requests, period, limit.Burst, limit.Key)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Who may register, chosen with the -registration flag
+const (
+	RegistrationOpen   = "open"
+	RegistrationClosed = "closed"
+	RegistrationInvite = "invite"
+	RegistrationDomain = "domain"
+)
+
+//How much of an invite code is kept in the clear to tell codes apart
+const inviteCodeShownLength = 4
+
+var (
+	//ErrRegistrationClosed if nobody may register
+	ErrRegistrationClosed = errors.New("Registration is closed")
+
+	//ErrInviteRequired if registration is invite only and no code was given
+	ErrInviteRequired = errors.New("An invite code is needed to register")
+
+	//ErrEmailDomain if the email is not in one of the domains allowed to register
+	ErrEmailDomain = errors.New("Registration is not open to your email domain")
+)
+
+//RegistrationPolicy tells the client who may register
+type RegistrationPolicy struct {
+	Mode    string
+	Domains []string `json:",omitempty"`
+}
+
+//registrationPolicy returns the policy set by the flags
+func registrationPolicy() RegistrationPolicy {
+	policy := RegistrationPolicy{Mode: strings.ToLower(*registrationMode)}
+	if policy.Mode == RegistrationDomain {
+		for _, domain := range strings.Split(*registrationDomains, ",") {
+			if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+				policy.Domains = append(policy.Domains, domain)
+			}
+		}
+	}
+	return policy
+}
+
+//validateRegistrationPolicy checks the flags so that a typo doesn't
+//leave registration open
+func validateRegistrationPolicy() error {
+	policy := registrationPolicy()
+	switch policy.Mode {
+	case RegistrationOpen, RegistrationClosed, RegistrationInvite:
+		return nil
+	case RegistrationDomain:
+		if len(policy.Domains) == 0 {
+			return errors.New("Registration by domain needs at least one domain, use -domains")
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown registration mode %s, use open, closed, invite or domain", policy.Mode)
+}
+
+//checkRegistration returns why the user may not register, nil if they may.
+//When registration is invite only the code is taken by createUser, so that a
+//registration failing after this check doesn't use it up
+func checkRegistration(user *User) error {
+	policy := registrationPolicy()
+	switch policy.Mode {
+	case RegistrationOpen:
+		return nil
+	case RegistrationDomain:
+		if !allowedEmailDomain(user.Email, policy.Domains) {
+			return ErrEmailDomain
+		}
+		return nil
+	case RegistrationInvite:
+		if strings.TrimSpace(user.InviteCode) == "" {
+			return ErrInviteRequired
+		}
+		if _, err := db.LookupUser(&User{Email: user.Email}); err == nil {
+			return ErrUserAlreadyExists
+		}
+		return nil
+	}
+	return ErrRegistrationClosed
+}
+
+//allowedEmailDomain returns true if the part of the email after the @
+//is one of the domains. Subdomains have to be listed by themselves
+func allowedEmailDomain(email string, domains []string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	return containsString(domains, strings.ToLower(email[at+1:]))
+}
+
+//writeRegistrationError tells the client why checkRegistration said no
+func writeRegistrationError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrUserAlreadyExists:
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("User already registered"))
+	case ErrRegistrationClosed, ErrInviteRequired, ErrEmailDomain, ErrNoInviteCode:
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+	default:
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to register"))
+	}
+}
+
+//createInviteCode stores a new invite code that can be used maxUses times
+//before it expires. A lifetime of 0 never expires. The code itself is
+//returned together with it, it is never stored and can't be shown again
+func createInviteCode(maxUses int, lifetime time.Duration) (*InviteCode, string, error) {
+	if maxUses < 1 {
+		return nil, "", errors.New("An invite code has to be usable at least once")
+	}
+	secret := randBase64String(12)
+	code := &InviteCode{
+		Prefix:  secret[:inviteCodeShownLength],
+		Hash:    hashToken(secret),
+		MaxUses: maxUses,
+	}
+	if lifetime > 0 {
+		code.ExpiresAt = time.Now().Add(lifetime)
+	}
+	if err := db.InsertInviteCode(code); err != nil {
+		return nil, "", err
+	}
+	return code, secret, nil
+}
+
+//parseLifetime reads a duration such as 48h, also accepting days as 7d
+func parseLifetime(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil || days < 1 {
+			return 0, fmt.Errorf("Invalid number of days: %s", value)
+		}
+		return time.Hour * 24 * time.Duration(days), nil
+	}
+	lifetime, err := time.ParseDuration(value)
+	if err != nil || lifetime <= 0 {
+		return 0, fmt.Errorf("Invalid lifetime %s, use for example 48h or 7d", value)
+	}
+	return lifetime, nil
+}
+
+//Tells the client who may register so the signup form can ask for an invite code
+func getRegistrationPolicy(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, registrationPolicy())
+}
@@ -1,6 +1,34 @@
 package main
 
+import (
+	"time"
+)
+
 //Response represents a json object to be returned to the client
 type Response struct {
-	Token string
+	Token        string
+	RefreshToken string `json:",omitempty"`
+}
+
+//SessionResponse describes one of the user's sessions without revealing its key
+type SessionResponse struct {
+	ID        int64
+	UserAgent string
+	IP        string
+	LoginTime time.Time
+	LastSeen  time.Time
+	Current   bool //True for the session the request was made with
+}
+
+//TwoFactorChallenge is returned instead of a token when the password was
+//correct but the user also has to enter a two-factor code
+type TwoFactorChallenge struct {
+	TwoFactorToken string
+}
+
+//TwoFactorResponse is returned while setting up two-factor authentication
+type TwoFactorResponse struct {
+	Secret        string   `json:",omitempty"`
+	URI           string   `json:",omitempty"` //otpauth uri to show as a qr code
+	RecoveryCodes []string `json:",omitempty"` //Only shown once, when two-factor is turned on
 }
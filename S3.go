@@ -0,0 +1,333 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//How long a presigned download link can be used
+	s3PresignLifetime = time.Minute * 15
+
+	s3Algorithm      = "AWS4-HMAC-SHA256"
+	s3TimeFormat     = "20060102T150405Z"
+	s3UnsignedSHA256 = "UNSIGNED-PAYLOAD"
+)
+
+func init() {
+	RegisterBlobStore("s3", openS3BlobStore)
+}
+
+//S3BlobStore keeps blobs as objects in a bucket of Amazon S3 or any
+//service compatible with it, such as MinIO. Requests are signed with
+//signature version 4, downloads are redirected to presigned urls so
+//that the content never passes through the server
+type S3BlobStore struct {
+	Endpoint  *url.URL //Scheme and host of the service, e.g. https://s3.eu-north-1.amazonaws.com
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool //Address the bucket as endpoint/bucket instead of bucket.endpoint
+	Client    *http.Client
+}
+
+//s3ListResult is the part of a ListObjectsV2 response that is used
+type s3ListResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+//s3ErrorResponse is the body S3 sends along with an error status
+type s3ErrorResponse struct {
+	Code    string
+	Message string
+}
+
+//openS3BlobStore creates an S3BlobStore from the configurations in .blob_cnf
+func openS3BlobStore(cnf map[string]string) (BlobStore, error) {
+	for _, key := range []string{"ENDPOINT", "BUCKET", "ACCESS_KEY", "SECRET_KEY"} {
+		if cnf[key] == "" {
+			return nil, errors.New("S3 blob store needs " + key)
+		}
+	}
+	endpoint, err := url.Parse(cnf["ENDPOINT"])
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "https" && endpoint.Scheme != "http") {
+		return nil, errors.New("S3 endpoint has to be an url such as https://s3.amazonaws.com")
+	}
+	store := &S3BlobStore{
+		Endpoint:  endpoint,
+		Region:    cnf["REGION"],
+		Bucket:    cnf["BUCKET"],
+		AccessKey: cnf["ACCESS_KEY"],
+		SecretKey: cnf["SECRET_KEY"],
+		Client:    &http.Client{Timeout: time.Minute},
+	}
+	if store.Region == "" {
+		store.Region = "us-east-1"
+	}
+	if cnf["PATH_STYLE"] != "" {
+		if store.PathStyle, err = strconv.ParseBool(cnf["PATH_STYLE"]); err != nil {
+			return nil, errors.New("S3 PATH_STYLE has to be true or false")
+		}
+	}
+	return store, nil
+}
+
+//Put uploads the content as the object key
+func (store *S3BlobStore) Put(key string, content io.Reader, size int64, hash string) error {
+	contentType := mime.TypeByExtension(strings.ToLower(path.Ext(key)))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	response, err := store.do("PUT", store.objectURL(key), content, size, hash, map[string]string{"Content-Type": contentType})
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+//Stat returns the size of the object key
+func (store *S3BlobStore) Stat(key string) (int64, error) {
+	response, err := store.do("HEAD", store.objectURL(key), nil, 0, "", nil)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	return response.ContentLength, nil
+}
+
+//Remove deletes the object key
+func (store *S3BlobStore) Remove(key string) error {
+	response, err := store.do("DELETE", store.objectURL(key), nil, 0, "", nil)
+	if err == ErrNoBlob {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+//List returns the objects directly in folder, following the
+//continuation tokens until the whole folder has been listed
+func (store *S3BlobStore) List(folder string) ([]string, error) {
+	var paths []string
+	continuation := ""
+	for {
+		listURL := store.objectURL("")
+		query := url.Values{"list-type": {"2"}, "prefix": {folder}, "delimiter": {"/"}}
+		if continuation != "" {
+			query.Set("continuation-token", continuation)
+		}
+		listURL.RawQuery = s3CanonicalQuery(query)
+
+		response, err := store.do("GET", listURL, nil, 0, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		result := new(s3ListResult)
+		err = xml.NewDecoder(response.Body).Decode(result)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			paths = append(paths, object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return paths, nil
+		}
+		continuation = result.NextContinuationToken
+	}
+}
+
+//ServeBlob redirects the client to a presigned url of the object
+func (store *S3BlobStore) ServeBlob(w http.ResponseWriter, r *http.Request, key string) {
+	http.Redirect(w, r, store.PresignedURL(key), http.StatusFound)
+}
+
+//PresignedURL returns an url anyone can download the object key
+//from, for as long as s3PresignLifetime
+func (store *S3BlobStore) PresignedURL(key string) string {
+	return store.presign("GET", key, time.Now(), s3PresignLifetime)
+}
+
+//presign signs the method on the object key into the query of its url
+func (store *S3BlobStore) presign(method, key string, at time.Time, lifetime time.Duration) string {
+	objectURL := store.objectURL(key)
+	at = at.UTC()
+	query := url.Values{
+		"X-Amz-Algorithm":     {s3Algorithm},
+		"X-Amz-Credential":    {store.AccessKey + "/" + store.scope(at)},
+		"X-Amz-Date":          {at.Format(s3TimeFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(lifetime.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	objectURL.RawQuery = s3CanonicalQuery(query)
+	_, signature := store.signature(method, objectURL, map[string]string{"host": objectURL.Host}, s3UnsignedSHA256, at)
+	query.Set("X-Amz-Signature", signature)
+	objectURL.RawQuery = s3CanonicalQuery(query)
+	return objectURL.String()
+}
+
+//objectURL returns the url of the object key, the bucket itself if key is empty
+func (store *S3BlobStore) objectURL(key string) *url.URL {
+	objectURL := &url.URL{Scheme: store.Endpoint.Scheme, Host: store.Endpoint.Host, Path: "/" + key}
+	if store.PathStyle {
+		objectURL.Path = "/" + store.Bucket + objectURL.Path
+	} else {
+		objectURL.Host = store.Bucket + "." + objectURL.Host
+	}
+	objectURL.RawPath = s3Escape(objectURL.Path, false)
+	return objectURL
+}
+
+//do sends a signed request. A missing object is returned as ErrNoBlob and
+//any other status that isn't a success as an error with the code S3 gave.
+//payloadHash is the hex encoded sha256 of the body, empty if there is none
+func (store *S3BlobStore) do(method string, requestURL *url.URL, body io.Reader, size int64, payloadHash string, headers map[string]string) (*http.Response, error) {
+	request, err := http.NewRequest(method, requestURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	request.ContentLength = size
+	if payloadHash == "" {
+		payloadHash = hashToken("")
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	at := time.Now().UTC()
+	request.Header.Set("X-Amz-Date", at.Format(s3TimeFormat))
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signed := map[string]string{"host": requestURL.Host}
+	for name := range request.Header {
+		signed[strings.ToLower(name)] = strings.TrimSpace(request.Header.Get(name))
+	}
+	signedHeaders, signature := store.signature(method, requestURL, signed, payloadHash, at)
+	request.Header.Set("Authorization", s3Algorithm+" Credential="+store.AccessKey+"/"+store.scope(at)+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+
+	client := store.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response, nil
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound && method != "GET" {
+		return nil, ErrNoBlob
+	}
+	s3Error := new(s3ErrorResponse)
+	xml.NewDecoder(io.LimitReader(response.Body, 4096)).Decode(s3Error)
+	if s3Error.Code == "NoSuchKey" {
+		return nil, ErrNoBlob
+	}
+	return nil, fmt.Errorf("S3 %s %s failed with %s: %s %s", method, requestURL.Path, response.Status, s3Error.Code, s3Error.Message)
+}
+
+//scope is the part of the credential that limits a signature to the day, region and service
+func (store *S3BlobStore) scope(at time.Time) string {
+	return at.Format("20060102") + "/" + store.Region + "/s3/aws4_request"
+}
+
+//signature signs the request as described for signature version 4 and
+//returns the names of the signed headers together with the signature
+func (store *S3BlobStore) signature(method string, requestURL *url.URL, headers map[string]string, payloadHash string, at time.Time) (string, string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		requestURL.EscapedPath(),
+		s3CanonicalQuery(requestURL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		at.Format(s3TimeFormat),
+		store.scope(at),
+		hashToken(canonicalRequest),
+	}, "\n")
+
+	key := []byte("AWS4" + store.SecretKey)
+	for _, part := range []string{at.Format("20060102"), store.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+//s3CanonicalQuery writes the query sorted and escaped the way S3 signs it
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+//s3Escape percent encodes everything except the unreserved characters,
+//and slashes unless escapeSlash is set
+func s3Escape(value string, escapeSlash bool) string {
+	var escaped strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', b == '/' && !escapeSlash:
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	//ErrUnknownStorage if no backend has been registered for the requested driver
+	ErrUnknownStorage = errors.New("No storage backend registered for the specified driver")
+
+	storageBackends = make(map[string]StorageOpener)
+)
+
+//Storage represents every operation the server needs from a persistent
+//store. The handlers only talk to this interface which makes it possible
+//to run the server on top of different backends
+type Storage interface {
+	IsFileReferenced(path string) (bool, error)
+	UniqueIdentifier(identifier string) bool
+	LookupUser(user *User) (*User, error)
+	GetUserIDFromPublicName(name string) (string, error)
+	AddUser(user *User) error
+	AddUserWithInvite(user *User, hash string) error
+	LookupPublicName(name string) (bool, error)
+	UpdatePublicName(uc *UserContents, user *User) error
+	GetUserContents(uid string, userContent *UserContents) (*UserContents, error)
+	UpdateUserContent(uid string, uc *UserContents) error
+	InsertUserSession(user *User) error
+	UpdateUserSession(user *User) error
+	GetUserSession(user *User) (*User, error)
+	GetUserSessions(uid string) ([]*UserSession, error)
+	RemoveUserSession(session *UserSession) error
+	RemoveUserSessionByID(uid string, id int64) error
+	CleanUserSession() error
+	InsertRefreshToken(token *RefreshToken) error
+	UseRefreshToken(hash string) (*RefreshToken, error)
+	InsertOneTimeToken(token *OneTimeToken) error
+	UseOneTimeToken(hash, purpose string) (*OneTimeToken, error)
+	RemoveOneTimeTokens(uid, purpose string) error
+	UpdatePassword(user *User) error
+	SetEmailVerified(uid string) error
+	GetTwoFactor(uid string) (*TwoFactor, error)
+	SaveTwoFactor(tf *TwoFactor) error
+	RemoveTwoFactor(uid string) error
+	UseTwoFactorStep(uid string, step int64) error
+	SetRecoveryCodes(uid string, hashes []string) error
+	UseRecoveryCode(uid, hash string) error
+	RemoveUserSessions(uid string) error
+	GetExternalIdentity(issuer, subject string) (*ExternalIdentity, error)
+	AddExternalIdentity(identity *ExternalIdentity) error
+	InsertAPIKey(key *APIKey) error
+	UseAPIKey(hash string) (*APIKey, error)
+	GetAPIKeys(uid string) ([]*APIKey, error)
+	RemoveAPIKey(uid string, id int64) error
+	InsertInviteCode(code *InviteCode) error
+	GetInviteCodes() ([]*InviteCode, error)
+	RemoveInviteCode(id int64) error
+	InsertUpload(upload *Upload, quota int64) error
+	GetUpload(path, uid string) (*Upload, error)
+	CountUploads(path string) (int, error)
+	GetUnreferencedUploads(before time.Time) ([]*Upload, error)
+	RemoveUpload(path, uid string) error
+	GetStorageUsed(uid string) (int64, error)
+	GetStorageQuota(uid string) (int64, error)
+	SetStorageQuota(uid string, quota int64) error
+	CloseConnection()
+}
+
+//StorageOpener creates a connected Storage from the configurations
+//read from .db_cnf
+type StorageOpener func(cnf map[string]string) (Storage, error)
+
+//RegisterStorage makes a backend available under the specified driver name.
+//Registering the same name twice replaces the previous backend
+func RegisterStorage(driverName string, opener StorageOpener) {
+	storageBackends[strings.ToLower(driverName)] = opener
+}
+
+//OpenStorage opens the backend registered for the DRIVERNAME
+//found in the provided configurations
+func OpenStorage(cnf map[string]string) (Storage, error) {
+	opener, ok := storageBackends[strings.ToLower(cnf["DRIVERNAME"])]
+	if !ok {
+		return nil, ErrUnknownStorage
+	}
+	return opener(cnf)
+}
+
+//readConfigurations parses a config file where every line holds
+//a key and a value separated by a space. Keys are returned in upper case
+func readConfigurations(r io.Reader) map[string]string {
+	cnf := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if data := scanner.Text(); strings.Contains(data, " ") {
+			insert := strings.SplitN(data, " ", 2)
+			cnf[strings.ToUpper(insert[0])] = insert[1]
+		}
+	}
+	return cnf
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//TOTP parameters from RFC 6238. They are the defaults every authenticator app supports
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1 //Codes from one step before or after are accepted as well
+	totpIssuer = "Malicious Mango"
+)
+
+//totpEncoding is base32 without padding as used in otpauth uris
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//newTOTPSecret generates a random 160 bit secret, base32 encoded
+func newTOTPSecret() string {
+	secret := make([]byte, 20)
+	rand.Read(secret)
+	return totpEncoding.EncodeToString(secret)
+}
+
+//totpURI returns the otpauth uri authenticator apps read from a qr code
+func totpURI(secret, email string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(totpIssuer + ":" + email)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+//totpCode computes the code of a time step as described in RFC 4226
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulo)
+}
+
+//validateTOTP checks the code against the secret at time now. The time step
+//the code belongs to is returned so the caller can refuse it a second time
+func validateTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	code = strings.Replace(code, " ", "", -1)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
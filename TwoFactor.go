@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	//The second login step has to be completed within this time
+	twoFactorLoginLifetime = time.Minute * 5
+
+	recoveryCodeCount = 10
+)
+
+//TwoFactorRequest is sent by the client with a code from the authenticator
+//app or a recovery code. Token is only used in the second login step
+type TwoFactorRequest struct {
+	Token string
+	Code  string
+}
+
+//Starts setting up two-factor authentication by generating a secret for the
+//user to add to an authenticator app. It isn't used until confirmed
+func enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !usingDatabase(w) {
+		return
+	}
+
+	user, err := handleToken(w, r) //feedback to client happens inside function
+	if err != nil {
+		return
+	}
+	if tf, err := db.GetTwoFactor(user.UserID); err == nil && tf.Enabled {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Two-factor authentication is already turned on"))
+		return
+	}
+	user, err = db.LookupUser(&User{UserID: user.UserID})
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("User not found"))
+		return
+	}
+
+	tf := &TwoFactor{UserID: user.UserID, Secret: newTOTPSecret()}
+	if err = db.SaveTwoFactor(tf); err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to set up two-factor authentication"))
+		return
+	}
+	writeJSON(w, TwoFactorResponse{Secret: tf.Secret, URI: totpURI(tf.Secret, user.Email)})
+}
+
+//Turns two-factor authentication on once the user has entered a code
+//generated from the new secret, and hands out the recovery codes
+func confirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !usingDatabase(w) {
+		return
+	}
+
+	user, err := handleToken(w, r) //feedback to client happens inside function
+	if err != nil {
+		return
+	}
+	request, err := readTwoFactorRequest(r)
+	tf, tfErr := db.GetTwoFactor(user.UserID)
+	if err != nil || tfErr != nil || tf.Enabled {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Start setting up two-factor authentication first"))
+		return
+	}
+	step, valid := validateTOTP(tf.Secret, request.Code, time.Now())
+	if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Invalid code"))
+		return
+	}
+
+	codes := newRecoveryCodes()
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = hashRecoveryCode(user.UserID, code)
+	}
+	tf.Enabled = true
+	tf.LastStep = step
+	err = db.SaveTwoFactor(tf)
+	if err == nil {
+		err = db.SetRecoveryCodes(user.UserID, hashes)
+	}
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to turn on two-factor authentication"))
+		return
+	}
+	writeJSON(w, TwoFactorResponse{RecoveryCodes: codes})
+}
+
+//Turns two-factor authentication off, which takes a current code
+//so that a stolen session can't do it
+func disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !usingDatabase(w) {
+		return
+	}
+
+	user, err := handleToken(w, r) //feedback to client happens inside function
+	if err != nil {
+		return
+	}
+	request, err := readTwoFactorRequest(r)
+	tf, tfErr := db.GetTwoFactor(user.UserID)
+	if err != nil || tfErr != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Two-factor authentication is not turned on"))
+		return
+	}
+	if tf.Enabled && !checkSecondFactor(tf, request.Code) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Invalid code"))
+		return
+	}
+	if err = db.RemoveTwoFactor(user.UserID); err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to turn off two-factor authentication"))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("Two-factor authentication is turned off"))
+}
+
+//Second login step for users with two-factor authentication. The token from
+//the first step can be used once, a wrong code means starting over. Wrong
+//codes count as failed logins so that codes can't be guessed by logging in again
+func loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !usingDatabase(w) {
+		return
+	}
+
+	request, err := readTwoFactorRequest(r)
+	if err != nil || request.Token == "" || request.Code == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Token and code are required"))
+		return
+	}
+	address := addressKey(clientIP(r))
+	if wait := loginGuard.Wait(address); wait > 0 {
+		writeLockedOut(w, wait)
+		return
+	}
+	token, err := db.UseOneTimeToken(hashToken(request.Token), TokenPurposeTwoFactor)
+	if err != nil || !token.ExpiresAt.After(time.Now()) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Login has expired, please log in again"))
+		return
+	}
+	user, err := db.LookupUser(&User{UserID: token.UserID})
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to log in"))
+		return
+	}
+	account := accountKey(user.Email)
+	if wait := loginGuard.Wait(account, address); wait > 0 {
+		writeLockedOut(w, wait)
+		return
+	}
+	tf, err := db.GetTwoFactor(user.UserID)
+	if err != nil || !tf.Enabled || !checkSecondFactor(tf, request.Code) {
+		loginGuard.Fail(account, loginAccountAttempts)
+		loginGuard.Fail(address, loginIPAttempts)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Invalid code, please log in again"))
+		return
+	}
+	loginGuard.Succeed(account)
+	writeNewToken(w, r, &User{UserID: user.UserID})
+}
+
+//requireSecondFactor starts the second login step if the user has two-factor
+//authentication turned on. Returns false if the user can be logged in right away
+func requireSecondFactor(w http.ResponseWriter, user *User) bool {
+	tf, err := db.GetTwoFactor(user.UserID)
+	if err == ErrNoTwoFactor || (err == nil && !tf.Enabled) {
+		return false
+	}
+	var token string
+	if err == nil {
+		token, err = issueOneTimeToken(user, TokenPurposeTwoFactor, twoFactorLoginLifetime)
+	}
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to log in"))
+		return true
+	}
+	writeJSON(w, TwoFactorChallenge{token})
+	return true
+}
+
+//checkSecondFactor accepts a code from the authenticator app, which can't
+//be used twice, or one of the recovery codes
+func checkSecondFactor(tf *TwoFactor, code string) bool {
+	if step, valid := validateTOTP(tf.Secret, code, time.Now()); valid {
+		return db.UseTwoFactorStep(tf.UserID, step) == nil
+	}
+	return db.UseRecoveryCode(tf.UserID, hashRecoveryCode(tf.UserID, code)) == nil
+}
+
+//newRecoveryCodes generates codes like abcde-fghij that can each
+//be used once instead of a code from the authenticator app
+func newRecoveryCodes() []string {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code := strings.ToLower(newTOTPSecret()[:10])
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes
+}
+
+//hashRecoveryCode hashes the code with the user id as salt. The codes are
+//short so a slow hash is used, the same code always gives the same hash
+//so it can be looked up
+func hashRecoveryCode(uid, code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	hash, _ := scrypt.Key([]byte(code), []byte(uid), 1<<14, 8, 1, 32)
+	return hex.EncodeToString(hash)
+}
+
+//readTwoFactorRequest parses the json body of the request
+func readTwoFactorRequest(r *http.Request) (*TwoFactorRequest, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	request := new(TwoFactorRequest)
+	err = json.Unmarshal(body, request)
+	return request, err
+}
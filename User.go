@@ -6,21 +6,105 @@ import (
 )
 
 //User provides a struct for gathering the user information
-//for the current user.
+//for the current user. Tokens and session are never read from the client
 type User struct {
-	Email    string
-	UserID   string
-	Password string
-	Salt     string
-	Token    string
-	Session  *UserSession
+	Email         string
+	UserID        string
+	Password      string
+	Salt          string
+	InviteCode    string       `json:",omitempty"` //Only needed to register when invite codes are required
+	EmailVerified bool         `json:"-"`
+	Token         string       `json:"-"`
+	RefreshToken  string       `json:"-"`
+	Session       *UserSession `json:"-"`
 }
 
-//UserSession holds the current session information for a specific user
+//UserSession holds the current session information for a specific user.
+//A user has one session for every login
 type UserSession struct {
+	ID         int64
 	SessionKey string
 	LoginTime  time.Time
 	LastSeen   time.Time
+	UserAgent  string
+	IP         string
+}
+
+//RefreshToken is a stored refresh token. Only the sha256 hash of the token
+//is kept. Every token belongs to the session it was issued for and can be
+//used once, using it a second time revokes the whole session
+type RefreshToken struct {
+	Hash      string
+	SessionID int64
+	UserID    string
+	Created   time.Time
+	ExpiresAt time.Time
+	Used      bool
+}
+
+//Purposes a OneTimeToken can be issued for
+const (
+	TokenPurposePasswordReset = "password-reset"
+	TokenPurposeVerifyEmail   = "verify-email"
+	TokenPurposeTwoFactor     = "two-factor"
+)
+
+//APIKey lets scripts act as a user without logging in, limited to its
+//scopes. Only the sha256 hash of the key is kept, Prefix is the start of
+//the key shown to tell keys apart
+type APIKey struct {
+	ID       int64
+	UserID   string
+	Name     string
+	Prefix   string
+	Hash     string
+	Scopes   []string
+	Created  time.Time
+	LastUsed time.Time //Zero if never used
+}
+
+//InviteCode lets people register when registration is invite only. Only
+//the sha256 hash of the code is kept, Prefix is the start of the code
+//shown to tell codes apart
+type InviteCode struct {
+	ID        int64
+	Prefix    string
+	Hash      string
+	MaxUses   int
+	Uses      int
+	Created   time.Time
+	ExpiresAt time.Time //Zero if the code never expires
+}
+
+//ExternalIdentity links an account at an OpenID Connect provider to a user.
+//The subject is the id of the account at the provider, which unlike the
+//email never changes
+type ExternalIdentity struct {
+	Issuer  string
+	Subject string
+	UserID  string
+	Email   string
+	Created time.Time
+}
+
+//OneTimeToken is a stored token that is mailed to the user and can be
+//used once before it expires. Only the sha256 hash of the token is kept
+type OneTimeToken struct {
+	Hash      string
+	UserID    string
+	Purpose   string
+	Created   time.Time
+	ExpiresAt time.Time
+}
+
+//TwoFactor holds the TOTP secret of a user. The secret is kept disabled
+//until the user has proven to have it by entering a code
+type TwoFactor struct {
+	UserID   string
+	Secret   string //Base32 encoded as in the otpauth uri
+	Enabled  bool
+	LastStep int64 //Time step of the last accepted code, codes can't be used twice
+	Created  time.Time
 }
 
 //UserContents holds information about users name, phone, email, pdf etc
@@ -34,12 +118,60 @@ type UserContents struct {
 	Description   string
 	PublicName    string
 	PDFs          []PDF
+	Storage       *StorageUsage `json:",omitempty"` //Only sent to the user themselves
 }
 
-//PDF represents a pdf file. Containing a Title and a search path
+//PDF represents a pdf file. Containing a Title and a search path.
+//Order, UploadTime and Size are kept by the database and never sent to the client
 type PDF struct {
-	Title string
-	Path  string
+	Title      string
+	Path       string
+	Order      int       `json:"-"`
+	UploadTime time.Time `json:"-"`
+	Size       int64     `json:"-"`
+}
+
+//Kinds of files a profile can refer to. They match the upload directories
+const (
+	FileKindPDF           = "pdf"
+	FileKindProfileHeader = "profile-header"
+	FileKindProfileIcon   = "profile-icon"
+)
+
+//FileReference records that the profile of a user refers to a stored file
+type FileReference struct {
+	Path   string
+	UserID string
+	Kind   string
+}
+
+//Upload records that a user uploaded the file at Path. Files are stored by
+//their content, so users uploading the same file share it. Only users who
+//uploaded a file can refer to it from their profile
+type Upload struct {
+	Path    string
+	UserID  string
+	Kind    string
+	Size    int64
+	Hash    string //Hex encoded sha256 of the content
+	Created time.Time
+}
+
+//fileReferences lists every file the content refers to
+func fileReferences(uid string, uc *UserContents) []FileReference {
+	var references []FileReference
+	if uc.ProfileIcon != "" {
+		references = append(references, FileReference{uc.ProfileIcon, uid, FileKindProfileIcon})
+	}
+	if uc.ProfileHeader != "" {
+		references = append(references, FileReference{uc.ProfileHeader, uid, FileKindProfileHeader})
+	}
+	for i := range uc.PDFs {
+		if uc.PDFs[i].Path != "" {
+			references = append(references, FileReference{uc.PDFs[i].Path, uid, FileKindPDF})
+		}
+	}
+	return references
 }
 
 func (pdf *PDF) String() string {
@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+//deliveries makes file names unique within the process
+var deliveries uint64
+
+//MaildirSender writes every message into a maildir, where any mail
+//client can be pointed at it. Handy for testing without a mail server
+type MaildirSender struct {
+	Dir string
+}
+
+//Send writes the message to tmp/ and moves it to new/ once complete,
+//as the maildir format requires
+func (sender *MaildirSender) Send(msg *Message) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(sender.Dir, sub), 0700); err != nil {
+			return err
+		}
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%d.M%dP%d_%d.%s", time.Now().Unix(), time.Now().Nanosecond()/1000, os.Getpid(), atomic.AddUint64(&deliveries, 1), host)
+	temp := filepath.Join(sender.Dir, "tmp", name)
+	if err = ioutil.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, filepath.Join(sender.Dir, "new", name))
+}
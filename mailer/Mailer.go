@@ -0,0 +1,114 @@
+//Package mailer sends mail for the server. Messages are rendered from
+//templates, handed to a Sender and delivered either over SMTP or written
+//to disk for local testing. A Queue in front of the sender retries failed
+//deliveries in the background so that handlers never wait for a mail server
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Sender delivers messages
+type Sender interface {
+	Send(msg *Message) error
+}
+
+//Message is a mail with a plain text body and an optional html body
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+//Bytes formats the message as it is sent over the wire. Messages
+//with an html body are sent as multipart/alternative
+func (msg *Message) Bytes() ([]byte, error) {
+	var buffer bytes.Buffer
+	header := func(key, value string) {
+		//Values come from users, line breaks would let them add headers
+		value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+		fmt.Fprintf(&buffer, "%s: %s\r\n", key, value)
+	}
+	if msg.From != "" {
+		header("From", msg.From)
+	}
+	header("To", strings.Join(msg.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+
+	if msg.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buffer.WriteString("\r\n")
+		err := writeQuotedPrintable(&buffer, msg.Text)
+		return buffer.Bytes(), err
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err = writeQuotedPrintable(w, part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	header("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	buffer.WriteString("\r\n")
+	buffer.Write(body.Bytes())
+	return buffer.Bytes(), nil
+}
+
+//writeQuotedPrintable encodes content so that no line is too long for SMTP
+func writeQuotedPrintable(w io.Writer, content string) error {
+	encoder := quotedprintable.NewWriter(w)
+	if _, err := encoder.Write([]byte(content)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+//WriterSender writes every message to Writer instead of delivering it,
+//which is useful when developing. It is safe for concurrent use
+type WriterSender struct {
+	mutex  sync.Mutex
+	Writer io.Writer
+}
+
+//Send writes the message followed by an empty line
+func (sender *WriterSender) Send(msg *Message) error {
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	if _, err = sender.Writer.Write(data); err != nil {
+		return err
+	}
+	_, err = io.WriteString(sender.Writer, "\r\n")
+	return err
+}
@@ -0,0 +1,236 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMessageAlternativeParts(t *testing.T) {
+	msg := &Message{To: []string{"alice@example.com"}, Subject: "Hej på dig", Text: "plain", HTML: "<b>html</b>"}
+	data, err := msg.Bytes()
+	if err != nil {
+		t.Fatalf("Unable to format message: %v", err)
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Message could not be parsed: %v", err)
+	}
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject")); subject != msg.Subject {
+		t.Fatalf("Subject was not encoded correctly: %q", subject)
+	}
+	_, params, _ := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	var bodies []string
+	for {
+		part, err := parts.NextPart()
+		if err != nil {
+			break
+		}
+		body, _ := ioutil.ReadAll(part)
+		bodies = append(bodies, string(body))
+	}
+	if len(bodies) != 2 || bodies[0] != "plain" || bodies[1] != "<b>html</b>" {
+		t.Fatalf("Expected a text and an html part, got %q", bodies)
+	}
+}
+
+func TestMessageHeaderInjection(t *testing.T) {
+	msg := &Message{To: []string{"alice@example.com\r\nBcc: eve@example.com"}, Text: "hi"}
+	data, _ := msg.Bytes()
+	if strings.Contains(string(data), "\r\nBcc:") {
+		t.Fatalf("Line breaks in headers should be removed:\n%s", data)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	files := fstest.MapFS{
+		"mail/welcome.txt":  {Data: []byte("Subject: Welcome {{.Name}}\n\nHello {{.Name}}\n")},
+		"mail/welcome.html": {Data: []byte("<p>Hello {{.Name}}</p>")},
+		"mail/plain.txt":    {Data: []byte("Subject: Plain\n\nJust text")},
+	}
+	templates, err := ParseTemplates(files, "mail")
+	if err != nil {
+		t.Fatalf("Unable to parse templates: %v", err)
+	}
+
+	msg, err := templates.Render("welcome", struct{ Name string }{"<Bob>"})
+	if err != nil {
+		t.Fatalf("Unable to render: %v", err)
+	}
+	if msg.Subject != "Welcome <Bob>" || msg.Text != "Hello <Bob>\n" || msg.HTML != "<p>Hello &lt;Bob&gt;</p>" {
+		t.Fatalf("Rendered wrong message: %+v", msg)
+	}
+	if msg, _ = templates.Render("plain", nil); msg.HTML != "" {
+		t.Fatalf("Messages without html template should be plain text")
+	}
+	if _, err = templates.Render("missing", nil); err != ErrUnknownTemplate {
+		t.Fatalf("Expected ErrUnknownTemplate, got %v", err)
+	}
+
+	files["mail/broken.txt"] = &fstest.MapFile{Data: []byte("No subject here")}
+	if _, err = ParseTemplates(files, "mail"); err != ErrNoSubject {
+		t.Fatalf("Expected ErrNoSubject, got %v", err)
+	}
+}
+
+func TestMaildirSender(t *testing.T) {
+	dir := t.TempDir()
+	sender := &MaildirSender{Dir: dir}
+	sender.Send(&Message{To: []string{"alice@example.com"}, Subject: "One", Text: "1"})
+	sender.Send(&Message{To: []string{"alice@example.com"}, Subject: "Two", Text: "2"})
+
+	delivered, _ := filepath.Glob(filepath.Join(dir, "new", "*"))
+	if len(delivered) != 2 {
+		t.Fatalf("Expected two messages in new/, got %v", delivered)
+	}
+	if pending, _ := filepath.Glob(filepath.Join(dir, "tmp", "*")); len(pending) != 0 {
+		t.Fatalf("Nothing should be left in tmp/, got %v", pending)
+	}
+}
+
+//flakySender fails the first failures sends
+type flakySender struct {
+	mutex    sync.Mutex
+	failures int
+	sent     []*Message
+}
+
+func (sender *flakySender) Send(msg *Message) error {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	if sender.failures > 0 {
+		sender.failures--
+		return errors.New("Server unavailable")
+	}
+	sender.sent = append(sender.sent, msg)
+	return nil
+}
+
+func TestQueueRetries(t *testing.T) {
+	sender := &flakySender{failures: 2}
+	queue := NewQueue(sender, 10, 3, time.Millisecond)
+	if err := queue.Send(&Message{Subject: "Retried"}); err != nil {
+		t.Fatalf("Unable to queue message: %v", err)
+	}
+	queue.Close(time.Second)
+	if len(sender.sent) != 1 {
+		t.Fatalf("Message should be delivered on the third attempt")
+	}
+	if err := queue.Send(&Message{}); err != ErrQueueClosed {
+		t.Fatalf("Expected ErrQueueClosed, got %v", err)
+	}
+}
+
+func TestQueueGivesUp(t *testing.T) {
+	sender := &flakySender{failures: 10}
+	queue := NewQueue(sender, 10, 2, time.Millisecond)
+	var failed []*Message
+	queue.Failed = func(msg *Message, err error) {
+		failed = append(failed, msg)
+	}
+	queue.Send(&Message{Subject: "Lost"})
+	queue.Close(time.Second)
+	if len(failed) != 1 || len(sender.sent) != 0 {
+		t.Fatalf("Message should be reported as failed after two attempts")
+	}
+}
+
+func TestQueueCloseTimeout(t *testing.T) {
+	sender := &flakySender{failures: 10}
+	queue := NewQueue(sender, 10, 5, time.Hour)
+	var failed []error
+	queue.Failed = func(msg *Message, err error) {
+		failed = append(failed, err)
+	}
+	queue.Send(&Message{Subject: "Waiting"})
+
+	start := time.Now()
+	if undelivered := queue.Close(time.Millisecond * 50); undelivered != 1 {
+		t.Fatalf("Expected one undelivered message, got %d", undelivered)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("Close should give up at the timeout, took %v", time.Since(start))
+	}
+	if len(failed) != 1 || failed[0] != ErrQueueClosed {
+		t.Fatalf("Undelivered message should be reported with ErrQueueClosed: %v", failed)
+	}
+}
+
+//fakeSMTPServer accepts a single mail and returns what it received
+func fakeSMTPServer(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	received := make(chan string, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		var transcript strings.Builder
+		reply("220 localhost ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			transcript.WriteString(line)
+			switch command := strings.ToUpper(strings.Fields(line)[0]); command {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "DATA":
+				reply("354 Go ahead")
+				for {
+					line, err = reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					transcript.WriteString(line)
+				}
+				reply("250 Queued")
+			case "QUIT":
+				reply("221 Bye")
+				received <- transcript.String()
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestSMTPSender(t *testing.T) {
+	address, received := fakeSMTPServer(t)
+	host, port, _ := net.SplitHostPort(address)
+	sender := &SMTPSender{Host: host, Port: port, From: "Mango <noreply@example.com>"}
+
+	err := sender.Send(&Message{To: []string{"Alice <alice@example.com>"}, Subject: "Hello", Text: "Hi Alice"})
+	if err != nil {
+		t.Fatalf("Unable to send: %v", err)
+	}
+	transcript := <-received
+	for _, expected := range []string{"MAIL FROM:<noreply@example.com>", "RCPT TO:<alice@example.com>", "Subject: Hello", "Hi Alice"} {
+		if !strings.Contains(transcript, expected) {
+			t.Fatalf("Expected %q in the smtp transcript:\n%s", expected, transcript)
+		}
+	}
+}
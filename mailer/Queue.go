@@ -0,0 +1,180 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	//ErrQueueFull if the queue can't take more messages right now
+	ErrQueueFull = errors.New("Mail queue is full")
+
+	//ErrQueueClosed if sending through a queue that has been closed, or
+	//if the queue was closed before the message could be delivered
+	ErrQueueClosed = errors.New("Mail queue is closed")
+)
+
+//Queue is a Sender that returns at once and delivers messages through
+//another Sender in the background. Failed deliveries are retried with
+//exponential backoff. It is safe for concurrent use
+type Queue struct {
+	sender      Sender
+	maxAttempts int
+	backoff     time.Duration
+
+	//Failed is called with messages that could not be delivered after
+	//every attempt, or before the queue was closed. They are printed if it's nil
+	Failed func(msg *Message, err error)
+
+	mutex    sync.Mutex
+	closed   bool
+	messages chan *delivery
+	pending  map[*delivery]bool //Queued or being retried, not yet delivered or given up
+	drained  chan struct{}      //Closed once the queue is closed and nothing is pending
+	stop     chan struct{}      //Closed to stop delivering
+}
+
+//delivery is a message waiting in the queue
+type delivery struct {
+	msg      *Message
+	attempts int
+}
+
+//NewQueue starts a queue holding at most size messages in front of sender.
+//Every message is tried maxAttempts times, waiting backoff after the first
+//failure and twice as long after each of the following
+func NewQueue(sender Sender, size, maxAttempts int, backoff time.Duration) *Queue {
+	queue := &Queue{
+		sender:      sender,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		messages:    make(chan *delivery, size),
+		pending:     make(map[*delivery]bool),
+		drained:     make(chan struct{}),
+		stop:        make(chan struct{}),
+	}
+	go queue.run()
+	return queue
+}
+
+//Send queues the message for delivery
+func (queue *Queue) Send(msg *Message) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.closed {
+		return ErrQueueClosed
+	}
+	next := &delivery{msg: msg}
+	select {
+	case queue.messages <- next:
+		queue.pending[next] = true
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+//Close stops accepting messages and waits until every queued message has
+//been delivered or has run out of attempts, but no longer than timeout.
+//Messages still undelivered by then are given up with ErrQueueClosed and
+//their number is returned
+func (queue *Queue) Close(timeout time.Duration) int {
+	queue.mutex.Lock()
+	if queue.closed {
+		queue.mutex.Unlock()
+		return 0
+	}
+	queue.closed = true
+	if len(queue.pending) == 0 {
+		close(queue.drained)
+	}
+	queue.mutex.Unlock()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	select {
+	case <-queue.drained:
+		close(queue.stop)
+		return 0
+	case <-deadline.C:
+	}
+	close(queue.stop)
+
+	queue.mutex.Lock()
+	var undelivered []*delivery
+	for next := range queue.pending {
+		undelivered = append(undelivered, next)
+		delete(queue.pending, next)
+	}
+	queue.mutex.Unlock()
+	for _, next := range undelivered {
+		queue.fail(next.msg, ErrQueueClosed)
+	}
+	return len(undelivered)
+}
+
+//run delivers messages until the queue is stopped
+func (queue *Queue) run() {
+	for {
+		var next *delivery
+		select {
+		case next = <-queue.messages:
+		case <-queue.stop:
+			return
+		}
+		err := queue.sender.Send(next.msg)
+		next.attempts++
+		switch {
+		case err == nil:
+			queue.done(next, nil)
+		case next.attempts >= queue.maxAttempts:
+			queue.done(next, err)
+		default:
+			//Wait outside the worker so other messages aren't held up
+			wait := queue.backoff << uint(next.attempts-1)
+			go func(retry *delivery) {
+				select {
+				case <-time.After(wait):
+				case <-queue.stop:
+					return
+				}
+				select {
+				case queue.messages <- retry:
+				case <-queue.stop:
+				}
+			}(next)
+		}
+	}
+}
+
+//done forgets a message that has been delivered, or reports it failed with
+//err, unless it was already given up because the queue was closed
+func (queue *Queue) done(next *delivery, err error) {
+	queue.mutex.Lock()
+	if !queue.pending[next] {
+		queue.mutex.Unlock()
+		return
+	}
+	delete(queue.pending, next)
+	last := queue.closed && len(queue.pending) == 0
+	queue.mutex.Unlock()
+
+	if err != nil {
+		queue.fail(next.msg, err)
+	}
+	if last {
+		close(queue.drained) //Reported before Close returns
+	}
+}
+
+//fail reports a message that could not be delivered
+func (queue *Queue) fail(msg *Message, err error) {
+	if queue.Failed != nil {
+		queue.Failed(msg, err)
+		return
+	}
+	fmt.Printf("Giving up sending mail %q to %v: %v\n", msg.Subject, msg.To, err)
+}
@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"net"
+	"net/mail"
+	"net/smtp"
+)
+
+//SMTPSender delivers messages through a mail server. STARTTLS is used
+//whenever the server supports it. Messages without a From address are
+//sent from From
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string //No authentication if empty
+	Password string
+	From     string
+}
+
+//Send delivers the message to every recipient
+func (sender *SMTPSender) Send(msg *Message) error {
+	if msg.From == "" {
+		copied := *msg
+		copied.From = sender.From
+		msg = &copied
+	}
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+	recipients := make([]string, len(msg.To))
+	for i := range msg.To {
+		to, err := mail.ParseAddress(msg.To[i])
+		if err != nil {
+			return err
+		}
+		recipients[i] = to.Address
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if sender.Username != "" {
+		auth = smtp.PlainAuth("", sender.Username, sender.Password, sender.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(sender.Host, sender.Port), auth, from.Address, recipients, data)
+}
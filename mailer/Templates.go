@@ -0,0 +1,115 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+var (
+	//ErrUnknownTemplate if no template with the requested name has been parsed
+	ErrUnknownTemplate = errors.New("No mail template with the specified name")
+
+	//ErrNoSubject if a text template does not start with a subject line
+	ErrNoSubject = errors.New("Mail template must start with a Subject: line")
+)
+
+//Templates renders messages from templates. Every message has a text template
+//<name>.txt whose first line is "Subject: <subject>", followed by the body.
+//An html body can be added in <name>.html. Both are executed with the data
+//given when rendering, html templates escape it as html
+type Templates struct {
+	templates map[string]*mailTemplate
+}
+
+//mailTemplate holds the parsed templates of one message
+type mailTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template //nil if the message is plain text only
+}
+
+//ParseTemplates parses every template in dir of fsys
+func ParseTemplates(fsys fs.FS, dir string) (*Templates, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := &Templates{templates: make(map[string]*mailTemplate)}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".txt") {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		name = strings.TrimSuffix(name, ".txt")
+		parsed, err := parseText(name, string(data))
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = fs.ReadFile(fsys, path.Join(dir, name+".html"))
+		if err == nil {
+			parsed.html, err = htmltemplate.New(name).Parse(string(data))
+		} else if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		templates.templates[name] = parsed
+	}
+	return templates, nil
+}
+
+//parseText splits a text template into its subject line and body
+func parseText(name, data string) (*mailTemplate, error) {
+	data = strings.Replace(data, "\r\n", "\n", -1)
+	lines := strings.SplitN(data, "\n", 2)
+	if !strings.HasPrefix(lines[0], "Subject:") {
+		return nil, ErrNoSubject
+	}
+	body := ""
+	if len(lines) == 2 {
+		body = strings.TrimLeft(lines[1], "\n")
+	}
+
+	var err error
+	parsed := new(mailTemplate)
+	parsed.subject, err = texttemplate.New(name).Parse(strings.TrimSpace(strings.TrimPrefix(lines[0], "Subject:")))
+	if err != nil {
+		return nil, err
+	}
+	parsed.text, err = texttemplate.New(name).Parse(body)
+	return parsed, err
+}
+
+//Render executes the templates of the message name with data
+func (templates *Templates) Render(name string, data interface{}) (*Message, error) {
+	parsed, ok := templates.templates[name]
+	if !ok {
+		return nil, ErrUnknownTemplate
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := parsed.subject.Execute(&subject, data); err != nil {
+		return nil, err
+	}
+	if err := parsed.text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if parsed.html != nil {
+		if err := parsed.html.Execute(&html, data); err != nil {
+			return nil, err
+		}
+	}
+	return &Message{Subject: subject.String(), Text: text.String(), HTML: html.String()}, nil
+}
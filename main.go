@@ -1,13 +1,14 @@
 package main
 
 import (
-	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	pseudoRand "math/rand"
 	"net/http"
@@ -21,33 +22,60 @@ import (
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
-	"github.com/gebi/scryptauth"
-	"github.com/kennygrant/sanitize"
 	"github.com/nytimes/gziphandler" //We might need some sort of license for this
-	"golang.org/x/crypto/scrypt"
+)
+
+//Images every new profile starts out with
+const (
+	defaultProfileHeader = "img/backgroundDefault.png"
+	defaultProfileIcon   = "img/profileDefault.png"
 )
 
 const (
 	_version = 0.3
 	port     = "8080"
-	//Since the code will be run by a raspberry pi, 4096 is the best
-	//we can do when it comes to cost for our key. Should be increased
-	//to 1048576 (1 << 20) when migrating to a more high end system.
-	_passwordCost = 1 << 12
+	//Access tokens are short lived, a client keeps its session alive by
+	//trading its refresh token for a new pair before the access token expires
+	accessTokenLifetime  = time.Minute * 5
+	refreshTokenLifetime = time.Hour * 24 * 30
 )
 
 var (
+	//ErrFileNotOwned if a profile refers to a file its user didn't upload
+	ErrFileNotOwned = errors.New("Profiles can only refer to files you have uploaded")
+
 	//since opening and closing the database is considered
 	//an expensive operation we keep this global to prevent
 	//unneccesairy calls to the sql api
-	db *DatabaseInterface
+	db Storage
 
 	_startTime = time.Now() //Last restart
 	quit       = make(chan bool)
-	secretKey  string
+
+	//keyRing holds the keys web tokens are signed with. Tests run on an
+	//in memory key ring, main replaces it with the one in the key file
+	keyRing = newEphemeralKeyRing()
+
+	storeName = flag.String("store", "", "Storage backend to use, overrides drivername in .db_cnf (e.g. memory)")
+	migrateDB = flag.Bool("migrate", false, "Apply pending database migrations and exit")
+	keyFile   = flag.String("keyfile", ".jwt_keys", "File holding the keys web tokens are signed with")
+	keyAlg    = flag.String("keyalg", "HS256", "Algorithm of new signing keys: HS256, RS256 or EdDSA")
+	publicURL = flag.String("url", "http://localhost:"+port, "Address users reach the server at, used in links sent by mail")
+	mailDir   = flag.String("maildir", "", "Maildir outgoing mail is written to when there is no .mail_cnf, mail is printed to stdout if empty")
+
+	passwordHashFlag = flag.String("passwordhash", PasswordScrypt, "Algorithm new password hashes use: scrypt or argon2id")
+	passwordCostFlag = flag.Int("passwordcost", passwordCost, "Cost (N) of new scrypt password hashes, a power of two")
+	argon2MemoryFlag = flag.Uint("argon2memory", uint(argon2Memory), "KiB of memory new argon2id password hashes use")
+	argon2TimeFlag   = flag.Uint("argon2time", uint(argon2Time), "Passes over the memory new argon2id password hashes make")
+
+	registrationMode    = flag.String("registration", RegistrationOpen, "Who may register: open, closed, invite or domain")
+	registrationDomains = flag.String("domains", "", "Comma separated email domains that may register when -registration is domain")
+
+	unverifiedRestrictions = flag.String("unverified", RestrictPublish, "Comma separated list of what accounts with an unverified email can't do: login, publish")
 )
 
 func main() {
+	flag.Parse()
 
 	if runtime.GOOS == "windows" {
 		c := exec.Command("cls")
@@ -60,8 +88,35 @@ func main() {
 	}
 
 	//Setup back-end
-	secretKey = randBase64String(128)
+	err := setPasswordHashing(*passwordHashFlag, *passwordCostFlag, *argon2MemoryFlag, *argon2TimeFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err = validateRegistrationPolicy(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	ring, err := LoadKeyRing(*keyFile, *keyAlg)
+	if err != nil {
+		fmt.Println("Unable to load signing keys from " + *keyFile + ":")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	keyRing = ring
+	setupMail()
+	loadRateLimits()
+	setupOIDC()
+	setupBlobStore()
 	db = connectToDatabase()
+	if *migrateDB {
+		if db == nil {
+			os.Exit(1)
+		}
+		db.CloseConnection()
+		fmt.Println("Database schema is up to date")
+		os.Exit(0)
+	}
 	go commandLineInterface(quit)
 	go SessionCleaner(quit)
 	go ImageCleaner(quit)
@@ -69,15 +124,40 @@ func main() {
 	fmt.Println("Listening on PORT: " + port)
 
 	//Setup client interface
-	http.HandleFunc("/api/login", login)
+	http.HandleFunc("/api/login", rateLimited("login", login))
 	http.HandleFunc("/api/logout", logout)
-	http.HandleFunc("/api/register", register)
+	http.HandleFunc("/api/register", rateLimited("register", register))
+	http.HandleFunc("/api/register/policy", getRegistrationPolicy)
 	http.HandleFunc("/api/refreshtoken", refreshToken)
+	http.HandleFunc("/api/token/refresh", refreshSession)
+	http.HandleFunc("/api/password/forgot", rateLimited("password-forgot", forgotPassword))
+	http.HandleFunc("/api/password/reset", resetPassword)
+	http.HandleFunc("/api/email/verify", verifyEmail)
+	http.HandleFunc("/api/email/resend", rateLimited("email-resend", resendVerification))
+	http.HandleFunc("/api/login/2fa", rateLimited("login-2fa", loginTwoFactor))
+	http.HandleFunc("/api/2fa/enroll", enrollTwoFactor)
+	http.HandleFunc("/api/2fa/confirm", confirmTwoFactor)
+	http.HandleFunc("/api/2fa/disable", disableTwoFactor)
+	http.HandleFunc("/api/oidc/provider", getOIDCProvider)
+	http.HandleFunc("/api/oidc/login", rateLimited("oidc-login", oidcLogin))
+	http.HandleFunc("/api/oidc/callback", oidcCallback)
+	http.HandleFunc("/api/oidc/token", oidcToken)
+	http.HandleFunc("/api/sessions", getSessions)
+	http.HandleFunc("/api/sessions/revoke/", revokeSession)
+	http.HandleFunc("/api/keys", getAPIKeys)
+	http.HandleFunc("/api/keys/create", newAPIKey)
+	http.HandleFunc("/api/keys/revoke/", revokeAPIKey)
 	http.HandleFunc("/api/profile/save", saveProfile)
 	http.HandleFunc("/api/profile/get-edit", getProfileEdit)
-	http.HandleFunc("/api/profile/get-view/", getProfileView)
+	http.HandleFunc("/api/profile/get-view/", rateLimited("get-view", getProfileView))
 
-	http.HandleFunc("/api/upload/", receiveUpload)
+	http.HandleFunc("/api/upload/", rateLimited("upload", receiveUpload))
+	http.HandleFunc("/.well-known/jwks.json", getJWKS)
+
+	//Uploads are served by the blob store, which may redirect elsewhere
+	for _, folder := range uploadFolders {
+		http.HandleFunc("/"+folder, serveUpload)
+	}
 
 	//Setup gzip for everything
 	fs := http.FileServer(http.Dir("www"))
@@ -86,72 +166,150 @@ func main() {
 	})
 	withGz := gziphandler.GzipHandler(withoutGz)
 	http.Handle("/", withGz)
-	err := http.ListenAndServe(":"+port, nil)
+	err = http.ListenAndServe(":"+port, nil)
 	if err != nil {
 		fmt.Println(err)
 	}
 }
 
-//Recieves a file from the client and handles saving it based on what content type
+//Stores a file uploaded by the logged in user. The kind of file is the
+//last part of the url: /api/upload/<pdf|profile-header|profile-icon>
 func receiveUpload(w http.ResponseWriter, r *http.Request) {
 	directories := make(map[string]string)
-	directories["pdf"] = "pdf/"
-	directories["profile-header"] = "img/profile-headers/"
-	directories["profile-icon"] = "img/profile-icons/"
+	directories[FileKindPDF] = "pdf/"
+	directories[FileKindProfileHeader] = "img/profile-headers/"
+	directories[FileKindProfileIcon] = "img/profile-icons/"
 	requestURLParts := strings.Split(r.RequestURI, "/")
 	if len(requestURLParts) < 2 {
 		return
 	}
 
-	if serverPath, ok := directories[requestURLParts[len(requestURLParts)-1]]; ok {
-		clientPath, err := saveFile(serverPath, r)
-		if err != nil {
-			w.WriteHeader(http.StatusNotAcceptable)
-			w.Write([]byte("Unable to upload file"))
-			return
+	kind := requestURLParts[len(requestURLParts)-1]
+	serverPath, ok := directories[kind]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Unknown kind of upload"))
+		return
+	}
+	if !usingDatabase(w) {
+		return
+	}
+	scope := ScopeUploadImage
+	if kind == FileKindPDF {
+		scope = ScopeUploadPDF
+	}
+	user, err := handleToken(w, r, scope) //feedback to client happens inside function
+	if err != nil {
+		return
+	}
+
+	usage, err := storageUsage(user.UserID)
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to upload file"))
+		return
+	}
+
+	//The body is cut off as soon as it gets larger than any allowed file
+	r.Body = http.MaxBytesReader(w, r.Body, int64(*maxUploadSizes[kind])+multipartOverhead)
+	upload, unlock, err := saveFile(kind, serverPath, usage.available(), r)
+	switch err {
+	case nil:
+	case ErrUnsupportedFileType:
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		w.Write([]byte(unsupportedFileMessage(kind)))
+		return
+	case ErrFileTooLarge:
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(tooLargeMessage(kind)))
+		return
+	case ErrQuotaExceeded:
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(quotaExceededMessage(usage)))
+		return
+	default:
+		w.WriteHeader(http.StatusNotAcceptable)
+		w.Write([]byte("Unable to upload file"))
+		return
+	}
+	//The blob stays locked until the upload has been recorded, or it could
+	//be released by someone else in between
+	defer unlock()
+	upload.UserID = user.UserID
+	upload.Kind = kind
+	//The quota is checked again as the upload is recorded, since other
+	//uploads of the user may have finished while this one was streamed
+	if _, err = db.GetUpload(upload.Path, user.UserID); err == ErrNoUpload {
+		err = db.InsertUpload(upload, usage.Quota)
+	}
+	if err == ErrQuotaExceeded {
+		removeUnusedBlob(upload.Path)
+		if current, err := storageUsage(user.UserID); err == nil {
+			usage = current
 		}
-		w.Write([]byte(clientPath))
-	} else {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(quotaExceededMessage(usage)))
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		removeUnusedBlob(upload.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to upload file"))
 		return
 	}
+	w.Write([]byte(upload.Path))
 }
 
-func saveFile(folder string, r *http.Request) (string, error) {
-	r.ParseMultipartForm(32 << 20)
+//saveFile stores the file of the request in folder by its content and
+//returns where together with its size and hash. The content has to be one
+//of the types allowed for the kind, which also decides the extension, and
+//fit both the largest size of the kind and the available storage. The file
+//is locked like putBlob leaves it
+func saveFile(kind, folder string, available int64, r *http.Request) (*Upload, func(), error) {
+	err := r.ParseMultipartForm(32 << 20)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, nil, ErrFileTooLarge
+	}
 	file, handler, err := r.FormFile("file")
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 	defer file.Close()
-	handler.Filename = sanitizeUploadFileName(handler.Filename, handler.Filename[(len(handler.Filename)-4):])
-	path := folder + handler.Filename
-
-	f, err := os.OpenFile("www/"+path, os.O_WRONLY|os.O_CREATE, 0666)
+	if handler.Size > int64(*maxUploadSizes[kind]) {
+		return nil, nil, ErrFileTooLarge
+	}
+	if handler.Size > available {
+		return nil, nil, ErrQuotaExceeded
+	}
+	fileType, err := sniffFileType(kind, file, handler.Size)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
-	defer f.Close()
-	io.Copy(f, file)
-
-	return path, nil
+	return putBlob(folder, fileType.Extension, file, handler.Size)
 }
 
-func sanitizeUploadFileName(name, extension string) string {
-	if db != nil {
-		nameInDatabase, err := db.UniversalLookup(name)
-		if nameInDatabase || err != nil {
-			name = randBase64String(50)
+//checkFileOwnership makes sure every file the content refers to was
+//uploaded by the user as the right kind, or is one of the default images
+func checkFileOwnership(uid string, uc *UserContents) error {
+	for _, reference := range fileReferences(uid, uc) {
+		if reference.Kind != FileKindPDF && (reference.Path == defaultProfileIcon || reference.Path == defaultProfileHeader) {
+			continue
+		}
+		upload, err := db.GetUpload(reference.Path, uid)
+		if err == ErrNoUpload {
+			return ErrFileNotOwned
+		}
+		if err != nil {
+			return err
+		}
+		if (reference.Kind == FileKindPDF) != (upload.Kind == FileKindPDF) {
+			return ErrFileNotOwned
 		}
 	}
-	if len(name) >= 150 {
-		var bytes bytes.Buffer
-		name = name[:50]
-		bytes.WriteString(name)
-		bytes.WriteString(extension)
-	}
-
-	path := sanitize.Path(name)
-	return path
+	return nil
 }
 
 //Checks the provided credentials and authenticates
@@ -170,19 +328,35 @@ func login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	passString := user.Password
+	account, address := accountKey(user.Email), addressKey(clientIP(r))
+	if wait := loginGuard.Wait(account, address); wait > 0 {
+		writeLockedOut(w, wait)
+		return
+	}
 
+	//Unknown users get the same answer after the same amount of work
+	//as a wrong password, so the response doesn't tell who is registered
+	allowed := false
 	user, err = db.LookupUser(user)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("User not found"))
-		return
+	if err == nil {
+		allowed = authenticatePassword(user, passString)
+	} else {
+		hashPassword(passString, unknownUserSalt)
 	}
 
-	allowed := authenticatePassword(user, passString)
 	if allowed {
+		if !allowedUnverified(w, user, RestrictLogin) {
+			return
+		}
+		//Failures are only forgotten once the second factor is passed as well
+		if requireSecondFactor(w, user) {
+			return
+		}
+		loginGuard.Succeed(account)
 		writeNewToken(w, r, user)
-		db.InsertUserSession(user)
 	} else {
+		loginGuard.Fail(account, loginAccountAttempts)
+		loginGuard.Fail(address, loginIPAttempts)
 		w.WriteHeader(http.StatusForbidden)
 		w.Write([]byte("Incorrect email or password"))
 	}
@@ -210,6 +384,62 @@ func refreshToken(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+//Trades a refresh token for a new access token and a new refresh token.
+//Refresh tokens can only be used once, presenting one a second time means
+//it has been stolen so the whole session is revoked
+func refreshSession(w http.ResponseWriter, r *http.Request) {
+	if !usingDatabase(w) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not read content"))
+		return
+	}
+	var request Response
+	if err = json.Unmarshal(body, &request); err != nil || request.RefreshToken == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("No refresh token provided"))
+		return
+	}
+
+	stored, err := db.UseRefreshToken(hashToken(request.RefreshToken))
+	if err == ErrRefreshTokenReused {
+		fmt.Println("Refresh token reused, revoking session of user " + stored.UserID)
+		db.RemoveUserSessionByID(stored.UserID, stored.SessionID)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Refresh token has already been used, please log in again"))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Invalid refresh token"))
+		return
+	}
+	if !stored.ExpiresAt.After(time.Now()) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Refresh token has expired"))
+		return
+	}
+
+	user := &User{UserID: stored.UserID, Session: &UserSession{ID: stored.SessionID}}
+	user.RefreshToken, err = issueRefreshToken(user)
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Session has been revoked"))
+		return
+	}
+	writeNewToken(w, r, user)
+	err = db.UpdateUserSession(user)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
 //Removes the active session for the user in database which will
 //make the rest of the code treat the user as not logged in
 func logout(w http.ResponseWriter, r *http.Request) {
@@ -236,6 +466,78 @@ func logout(w http.ResponseWriter, r *http.Request) {
 
 }
 
+//Lists the active sessions of the logged in user
+func getSessions(w http.ResponseWriter, r *http.Request) {
+	if !usingDatabase(w) {
+		return
+	}
+
+	user, err := handleToken(w, r) //feedback to client happens inside function
+	if err != nil {
+		return
+	}
+	sessions, err := db.GetUserSessions(user.UserID)
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to read sessions"))
+		return
+	}
+
+	response := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = SessionResponse{
+			ID:        session.ID,
+			UserAgent: session.UserAgent,
+			IP:        session.IP,
+			LoginTime: session.LoginTime,
+			LastSeen:  session.LastSeen,
+			Current:   session.ID == user.Session.ID,
+		}
+	}
+	JSON, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to send sessions"))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(JSON)
+}
+
+//Revokes one of the logged in user's sessions. The session id
+//is the last part of the url
+func revokeSession(w http.ResponseWriter, r *http.Request) {
+	if !usingDatabase(w) {
+		return
+	}
+
+	user, err := handleToken(w, r) //feedback to client happens inside function
+	if err != nil {
+		return
+	}
+	requestURLParts := strings.Split(r.RequestURI, "/")
+	id, err := strconv.ParseInt(requestURLParts[len(requestURLParts)-1], 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid session id"))
+		return
+	}
+
+	err = db.RemoveUserSessionByID(user.UserID, id)
+	if err == ErrNoActiveSession {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Session not found"))
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to revoke session"))
+		return
+	}
+}
+
 //Encrypts the users password and registers it in the database
 func register(w http.ResponseWriter, r *http.Request) {
 	if !usingDatabase(w) {
@@ -249,49 +551,72 @@ func register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err = checkRegistration(user); err != nil {
+		writeRegistrationError(w, err)
+		return
+	}
+
+	newUserIdentifiers(user)
+	user.Password = hashPassword(user.Password, user.Salt)
+	err = createUser(user)
+	if err == ErrNoInviteCode {
+		writeRegistrationError(w, err)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("User already registered"))
+		return
+	}
+
+	if err = sendVerificationMail(user); err != nil {
+		fmt.Println(err)
+	}
+	if restricted(RestrictLogin) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("Account created, follow the link we mailed you to log in"))
+		return
+	}
+	writeNewToken(w, r, user)
+}
+
+//newUserIdentifiers gives the user a new user id and salt,
+//both unique among every user
+func newUserIdentifiers(user *User) {
 	//64 and 128 is a result of Database limitations and security recomendations
 	for {
 		user.UserID = randBase64String(64)
 		user.Salt = randBase64String(128)
 		if db.UniqueIdentifier(user.UserID) && db.UniqueIdentifier(user.Salt) {
-			break
+			return
 		}
 	}
+}
 
-	passwordHash, _ := scrypt.Key([]byte(user.Password), []byte(user.Salt), _passwordCost, 8, 1, 128)
-	passwordHash64 := scryptauth.EncodeBase64(_passwordCost, []byte(passwordHash), []byte(user.Salt))
-	user.Password = string(passwordHash64)
-
-	err = db.AddUser(user)
+//createUser adds the user together with a default profile. When
+//registration is invite only a use of the invite code is taken at once
+func createUser(user *User) error {
+	var err error
+	if registrationPolicy().Mode == RegistrationInvite {
+		err = db.AddUserWithInvite(user, hashToken(strings.TrimSpace(user.InviteCode)))
+	} else {
+		err = db.AddUser(user)
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusConflict)
-		w.Write([]byte("User already registered"))
-		return
+		return err
 	}
 
 	//Because we don't want the user to reneter email
 	userContent := new(UserContents)
 	userContent.EMail = user.Email
-	userContent.ProfileHeader = "img/backgroundDefault.png"
-	userContent.ProfileIcon = "img/profileDefault.png"
+	userContent.ProfileHeader = defaultProfileHeader
+	userContent.ProfileIcon = defaultProfileIcon
 	userContent.FullName = "Full Name"
 	userContent.Description = "Descriotion"
 	userContent.Phone = "Phone"
 	userContent.UserID = user.UserID
 	db.UpdateUserContent(user.UserID, userContent)
-
-	writeNewToken(w, r, user)
-	db.InsertUserSession(user)
-
-}
-
-//Generates a KDF from the provided password and user salt and compares them
-//Returns true/false if hashes match
-func authenticatePassword(user *User, password string) bool {
-	passwordHash, _ := scrypt.Key([]byte(password), []byte(user.Salt), _passwordCost, 8, 1, 128)
-	passwordHash64 := scryptauth.EncodeBase64(_passwordCost, []byte(passwordHash), []byte(user.Salt))
-	return (string(passwordHash64) == user.Password)
-
+	return nil
 }
 
 //Returns a profile to the client
@@ -326,7 +651,7 @@ func getProfileView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeUserContentToClient(w, r, user)
+	writeUserContentToClient(w, r, user, false)
 }
 
 //Validates token and returns a profile to client for edit
@@ -335,15 +660,16 @@ func getProfileEdit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := handleToken(w, r) //feedback to client happens inside function
+	user, err := handleToken(w, r, ScopeProfileRead) //feedback to client happens inside function
 	if err != nil {
 		return
 	}
-	writeUserContentToClient(w, r, user)
+	writeUserContentToClient(w, r, user, true)
 }
 
-//Writes UserContent from database to client
-func writeUserContentToClient(w http.ResponseWriter, r *http.Request, user *User) {
+//Writes UserContent from database to client. The owner of
+//the content also gets to see how much storage they use
+func writeUserContentToClient(w http.ResponseWriter, r *http.Request, user *User, owner bool) {
 	if !usingDatabase(w) {
 		return
 	}
@@ -357,6 +683,11 @@ func writeUserContentToClient(w http.ResponseWriter, r *http.Request, user *User
 		return
 	}
 	userContent.UserID = "" //Since it potentially could be exploited if we sent uid to client
+	if owner {
+		if userContent.Storage, err = storageUsage(user.UserID); err != nil {
+			fmt.Println(err)
+		}
+	}
 	JSON, err := json.Marshal(userContent)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -373,10 +704,13 @@ func saveProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := handleToken(w, r) //feedback to client happens inside function
+	tokenUser, err := handleToken(w, r, ScopeProfileWrite) //feedback to client happens inside function
 	if err != nil {
 		return
 	}
+	if !allowedUnverified(w, tokenUser, RestrictPublish) {
+		return
+	}
 	body, err := ioutil.ReadAll(r.Body)
 	defer r.Body.Close()
 	if err != nil {
@@ -392,15 +726,25 @@ func saveProfile(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Unexpected end of json-input"))
 		return
 	}
-	user := new(User)
-	user.Token = strings.Split(r.Header.Get("Authorization"), " ")[1]
-	_, err = db.GetUserSession(user)
+	userContent.Storage = nil //Only ever computed by the server
+	err = checkFileOwnership(tokenUser.UserID, userContent)
+	if err == ErrFileNotOwned {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("No active session"))
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not save content"))
 		return
 	}
-	err = db.UpdateUserContent(user.UserID, userContent)
+	for i := range userContent.PDFs {
+		if size, err := blobs.Stat(userContent.PDFs[i].Path); err == nil {
+			userContent.PDFs[i].Size = size
+		}
+	}
+	err = db.UpdateUserContent(tokenUser.UserID, userContent)
 	if err != nil {
 		w.WriteHeader(http.StatusRequestEntityTooLarge)
 		fmt.Println(err)
@@ -426,21 +770,24 @@ func saveProfile(w http.ResponseWriter, r *http.Request) {
 
 		publicName += strNrExtension
 		userContent.PublicName = publicName
-		db.UpdatePublicName(userContent, user)
+		db.UpdatePublicName(userContent, tokenUser)
 
 		//cat and insert to database
 	} else {
 		userContent.PublicName = publicName
-		db.UpdatePublicName(userContent, user)
+		db.UpdatePublicName(userContent, tokenUser)
 	}
 } // End saveProfile
 
-//Uses the jwt-library and the secretKey to generate a signed jwt
+//Uses the jwt-library and the current signing key to generate a signed jwt
 func generateToken(userID string) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+	key := keyRing.SigningKey()
+	token := jwt.New(key.SigningMethod())
+	token.Header["kid"] = key.ID
 	token.Claims["uid"] = userID
-	token.Claims["exp"] = time.Now().Add(time.Minute * 5).Unix()
-	tokenString, err := token.SignedString([]byte(secretKey))
+	token.Claims["exp"] = time.Now().Add(accessTokenLifetime).Unix()
+	token.Claims["jti"] = randBase64String(16) //Tokens are session keys, every login needs its own
+	tokenString, err := token.SignedString(key.SignKey())
 	if err != nil {
 		fmt.Println(err)
 		return "", err
@@ -448,6 +795,42 @@ func generateToken(userID string) (string, error) {
 	return tokenString, nil
 }
 
+//newUserSession describes the device a login request was made from
+func newUserSession(r *http.Request) *UserSession {
+	session := new(UserSession)
+	session.UserAgent = r.UserAgent()
+	if len(session.UserAgent) > 255 {
+		session.UserAgent = session.UserAgent[:255]
+	}
+	session.IP = clientIP(r)
+	return session
+}
+
+//issueRefreshToken creates a refresh token for the session in user.Session.
+//Only its hash is stored, the token itself is returned to be sent to the client
+func issueRefreshToken(user *User) (string, error) {
+	token := randBase64String(32)
+	now := time.Now()
+	err := db.InsertRefreshToken(&RefreshToken{
+		Hash:      hashToken(token),
+		SessionID: user.Session.ID,
+		UserID:    user.UserID,
+		Created:   now,
+		ExpiresAt: now.Add(refreshTokenLifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+//hashToken returns the hex encoded sha256 hash of an opaque token. The tokens
+//are random enough that a plain hash is sufficient for storing them
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
 //Reads n crypto random bytes and return them as a base64 encoded string
 func randBase64String(n int) string {
 	bytes := make([]byte, n)
@@ -521,8 +904,10 @@ func validateEmail(email string) error {
 }
 
 //handleToken takes care of reading and validating the token provided by the client
-//returns a user containing token and user session
-func handleToken(w http.ResponseWriter, r *http.Request) (*User, error) {
+//returns a user containing token and user session. An api key is accepted
+//instead of a web token if scopes are given and the key has all of them,
+//the user then has no session
+func handleToken(w http.ResponseWriter, r *http.Request, scopes ...string) (*User, error) {
 	user := new(User)
 	providedTokens := strings.Split(r.Header.Get("Authorization"), " ")
 	if len(providedTokens) != 2 {
@@ -531,6 +916,9 @@ func handleToken(w http.ResponseWriter, r *http.Request) (*User, error) {
 		return nil, errors.New("Invlaid number of tokens provided")
 	}
 	user.Token = providedTokens[1]
+	if strings.HasPrefix(user.Token, apiKeyPrefix) {
+		return handleAPIKey(w, user.Token, scopes)
+	}
 
 	user, err := db.GetUserSession(user)
 	if err != nil {
@@ -548,14 +936,9 @@ func handleToken(w http.ResponseWriter, r *http.Request) (*User, error) {
 	return user, nil
 }
 
-//Validates a token's signing method, userID and expiration date
+//Validates a token's signing method, key id, userID and expiration date
 func validateToken(user *User) (bool, *jwt.Token) {
-	token, err := jwt.Parse(user.Session.SessionKey, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(secretKey), nil
-	})
+	token, err := jwt.Parse(user.Session.SessionKey, tokenVerifyKey)
 	if err != nil {
 		fmt.Println(err)
 		return false, nil
@@ -573,7 +956,53 @@ func validateToken(user *User) (bool, *jwt.Token) {
 	return false, token
 }
 
-//Generates a token and writes it to the client
+//tokenVerifyKey returns the key of the key ring the token was signed with
+func tokenVerifyKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := keyRing.Key(kid)
+	if err != nil {
+		return nil, err
+	}
+	//The key decides the algorithm, never the token
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.VerifyKey(), nil
+}
+
+//tokenUserID returns the user a request carries a valid web token for.
+//Unlike handleToken it doesn't check that the session is still alive,
+//which is good enough to tell clients apart without asking the database
+func tokenUserID(r *http.Request) (string, bool) {
+	providedTokens := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(providedTokens) != 2 {
+		return "", false
+	}
+	token, err := jwt.Parse(providedTokens[1], tokenVerifyKey)
+	if err != nil || !token.Valid {
+		return "", false
+	}
+	uid, ok := token.Claims["uid"].(string)
+	return uid, ok && uid != ""
+}
+
+//Publishes the public keys tokens are signed with so that other
+//services can verify them. Shared HS256 secrets are never published
+func getJWKS(w http.ResponseWriter, r *http.Request) {
+	JSON, err := json.Marshal(map[string][]JSONWebKey{"keys": keyRing.JWKS()})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to send keys"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=300")
+	w.Write(JSON)
+}
+
+//Generates a token and writes it to the client. A user without a session
+//has just logged in, so a new session is started and a refresh token for it
+//is sent along with the access token
 func writeNewToken(w http.ResponseWriter, r *http.Request, user *User) {
 	token, err := generateToken(user.UserID)
 	if err != nil {
@@ -583,7 +1012,21 @@ func writeNewToken(w http.ResponseWriter, r *http.Request, user *User) {
 	}
 	user.Token = token
 
-	JSON, err := json.Marshal(Response{token})
+	if user.Session == nil {
+		user.Session = newUserSession(r)
+		err = db.InsertUserSession(user)
+		if err == nil {
+			user.RefreshToken, err = issueRefreshToken(user)
+		}
+		if err != nil {
+			fmt.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Unable to start session"))
+			return
+		}
+	}
+
+	JSON, err := json.Marshal(Response{token, user.RefreshToken})
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -591,6 +1034,19 @@ func writeNewToken(w http.ResponseWriter, r *http.Request, user *User) {
 	w.Write(JSON)
 }
 
+//writeJSON sends the value to the client as json
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	JSON, err := json.Marshal(value)
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Unable to send response"))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(JSON)
+}
+
 func usingDatabase(w http.ResponseWriter) bool {
 	if db == nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -600,22 +1056,39 @@ func usingDatabase(w http.ResponseWriter) bool {
 	return true
 }
 
-//Tries to open a connection to the database
-//On success: global value db is assigned a DatabaseInterface-struct
-//On failure: global value db is assigned nil
-func connectToDatabase() *DatabaseInterface {
+//Tries to open a connection to the storage backend named in .db_cnf,
+//or the one given by the -store flag
+//On success: the returned Storage is connected and ready for use
+//On failure: nil is returned and the server continues without database
+func connectToDatabase() Storage {
+	cnf := make(map[string]string)
 	conf, err := os.Open(".db_cnf")
-	if err != nil {
-		db = nil
+	if err == nil {
+		cnf = readConfigurations(conf)
+		conf.Close()
+	} else if *storeName == "" {
 		fmt.Println("No database config file detected")
 		fmt.Println("Continuing without database")
 		return nil
 	}
-	defer conf.Close()
+	if *storeName != "" {
+		cnf["DRIVERNAME"] = *storeName
+	}
+	if *migrateDB {
+		cnf["AUTOMIGRATE"] = "true"
+	}
 
-	db := new(DatabaseInterface)
-	db.SetConfigurations(conf)
-	err = db.OpenConnection()
+	store, err := OpenStorage(cnf)
+	if err == ErrSchemaAhead {
+		fmt.Println("Refusing to start: the database schema is newer than this server")
+		fmt.Println("Upgrade the server or revert the schema with an up to date binary")
+		os.Exit(1)
+	}
+	if err == ErrSchemaBehind {
+		fmt.Println("Refusing to start: the database has migrations pending")
+		fmt.Println("Apply them with -migrate, or add automigrate true to .db_cnf")
+		os.Exit(1)
+	}
 	if err != nil {
 		fmt.Println("Failed to connect to database with error:")
 		fmt.Println(err)
@@ -623,7 +1096,7 @@ func connectToDatabase() *DatabaseInterface {
 		return nil
 	}
 	fmt.Println("Successfully connected to database")
-	return db
+	return store
 }
 
 //Takes care of closing operations
@@ -632,6 +1105,12 @@ func closeServer() {
 	if db != nil {
 		db.CloseConnection()
 	}
+	if mailQueue != nil {
+		//Gives queued mail a moment to be delivered, the queue reports what wasn't
+		if undelivered := mailQueue.Close(mailShutdownTimeout); undelivered > 0 {
+			fmt.Printf("%d mails were not delivered before shutting down\n", undelivered)
+		}
+	}
 	close(quit) //Exits all runnign go routines
 	os.Exit(0)
 }
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS `UserContent`;
+DROP TABLE IF EXISTS `Users`;
+DROP TABLE IF EXISTS `UserSession`;
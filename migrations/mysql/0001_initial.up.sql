@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS `UserSession` (`SessionKey` varchar(512) COLLATE utf8_unicode_ci NOT NULL,`UserId` varchar(128) COLLATE utf8_unicode_ci NOT NULL,`LoginTime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,`LastSeenTime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+CREATE TABLE IF NOT EXISTS `Users` (`EMail` varchar(80) COLLATE utf8_unicode_ci NOT NULL,`UserId` varchar(128) COLLATE utf8_unicode_ci DEFAULT '',`Password` varchar(512) COLLATE utf8_unicode_ci DEFAULT '',`PasswordSalt` varchar(512) COLLATE utf8_unicode_ci DEFAULT NULL,PRIMARY KEY (`EMail`),UNIQUE KEY `EMail` (`EMail`)) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+CREATE TABLE IF NOT EXISTS `UserContent` (`UserId` varchar(128) COLLATE utf8_unicode_ci NOT NULL,`FullName` varchar(70) COLLATE utf8_unicode_ci NOT NULL,`Phone` varchar(50) COLLATE utf8_unicode_ci NOT NULL,`EMail` varchar(80) COLLATE utf8_unicode_ci NOT NULL,`ProfileIcon` varchar(150) COLLATE utf8_unicode_ci NOT NULL,`ProfileHeader` varchar(150) COLLATE utf8_unicode_ci NOT NULL,`Description` varchar(360) COLLATE utf8_unicode_ci NOT NULL,`PublicName` varchar(80) COLLATE utf8_unicode_ci NOT NULL,`PDFs` varchar(20000) COLLATE utf8_unicode_ci NOT NULL DEFAULT '') ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
@@ -0,0 +1,7 @@
+DROP INDEX `UserContent_PublicName` ON `UserContent`;
+DROP INDEX `UserContent_UserId` ON `UserContent`;
+DROP INDEX `Users_UserId` ON `Users`;
+DROP INDEX `UserSession_LastSeenTime` ON `UserSession`;
+DROP INDEX `UserSession_UserId` ON `UserSession`;
+DROP INDEX `UserSession_SessionKey` ON `UserSession`;
+ALTER TABLE `UserContent` MODIFY `PDFs` varchar(20000) COLLATE utf8_unicode_ci NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+ALTER TABLE `UserContent` MODIFY `PDFs` mediumtext COLLATE utf8_unicode_ci NOT NULL;
+CREATE INDEX `UserSession_SessionKey` ON `UserSession` (`SessionKey`(255));
+CREATE INDEX `UserSession_UserId` ON `UserSession` (`UserId`);
+CREATE INDEX `UserSession_LastSeenTime` ON `UserSession` (`LastSeenTime`);
+CREATE INDEX `Users_UserId` ON `Users` (`UserId`);
+CREATE INDEX `UserContent_UserId` ON `UserContent` (`UserId`);
+CREATE INDEX `UserContent_PublicName` ON `UserContent` (`PublicName`);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `UserPDFs`;
+DROP INDEX `Users_UserId_Unique` ON `Users`;
@@ -0,0 +1,14 @@
+CREATE UNIQUE INDEX `Users_UserId_Unique` ON `Users` (`UserId`);
+CREATE TABLE IF NOT EXISTS `UserPDFs` (
+  `Id` int NOT NULL AUTO_INCREMENT,
+  `UserId` varchar(128) COLLATE utf8_unicode_ci NOT NULL,
+  `Title` varchar(255) COLLATE utf8_unicode_ci NOT NULL DEFAULT '',
+  `Path` varchar(255) COLLATE utf8_unicode_ci NOT NULL,
+  `Position` int NOT NULL,
+  `UploadTime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `Size` bigint NOT NULL DEFAULT 0,
+  PRIMARY KEY (`Id`),
+  KEY `UserPDFs_UserId` (`UserId`, `Position`),
+  KEY `UserPDFs_Path` (`Path`),
+  CONSTRAINT `UserPDFs_User` FOREIGN KEY (`UserId`) REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
@@ -0,0 +1 @@
+ALTER TABLE `UserContent` ADD COLUMN `PDFs` mediumtext COLLATE utf8_unicode_ci NOT NULL;
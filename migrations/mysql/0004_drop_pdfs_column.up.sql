@@ -0,0 +1 @@
+ALTER TABLE `UserContent` DROP COLUMN `PDFs`;
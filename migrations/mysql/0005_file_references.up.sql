@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `FileReferences` (
+  `Id` int NOT NULL AUTO_INCREMENT,
+  `Path` varchar(255) COLLATE utf8_unicode_ci NOT NULL,
+  `UserId` varchar(128) COLLATE utf8_unicode_ci NOT NULL,
+  `Kind` varchar(20) COLLATE utf8_unicode_ci NOT NULL,
+  PRIMARY KEY (`Id`),
+  KEY `FileReferences_Path` (`Path`),
+  KEY `FileReferences_UserId` (`UserId`),
+  CONSTRAINT `FileReferences_User` FOREIGN KEY (`UserId`) REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+INSERT INTO `FileReferences` (`Path`, `UserId`, `Kind`) SELECT `ProfileIcon`, `UserId`, 'profile-icon' FROM `UserContent` WHERE `ProfileIcon` <> '' AND `UserId` IN (SELECT `UserId` FROM `Users`);
+INSERT INTO `FileReferences` (`Path`, `UserId`, `Kind`) SELECT `ProfileHeader`, `UserId`, 'profile-header' FROM `UserContent` WHERE `ProfileHeader` <> '' AND `UserId` IN (SELECT `UserId` FROM `Users`);
+INSERT INTO `FileReferences` (`Path`, `UserId`, `Kind`) SELECT `Path`, `UserId`, 'pdf' FROM `UserPDFs` WHERE `Path` <> '';
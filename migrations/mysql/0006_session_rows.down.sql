@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS `UserSession`;
+CREATE TABLE `UserSession` (`SessionKey` varchar(512) COLLATE utf8_unicode_ci NOT NULL,`UserId` varchar(128) COLLATE utf8_unicode_ci NOT NULL,`LoginTime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,`LastSeenTime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+CREATE INDEX `UserSession_SessionKey` ON `UserSession` (`SessionKey`(255));
+CREATE INDEX `UserSession_UserId` ON `UserSession` (`UserId`);
+CREATE INDEX `UserSession_LastSeenTime` ON `UserSession` (`LastSeenTime`);
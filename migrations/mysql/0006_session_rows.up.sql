@@ -0,0 +1,15 @@
+DROP TABLE IF EXISTS `UserSession`;
+CREATE TABLE `UserSession` (
+  `Id` bigint NOT NULL AUTO_INCREMENT,
+  `SessionKey` varchar(512) COLLATE utf8_unicode_ci NOT NULL,
+  `UserId` varchar(128) COLLATE utf8_unicode_ci NOT NULL,
+  `LoginTime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `LastSeenTime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `UserAgent` varchar(255) COLLATE utf8_unicode_ci NOT NULL DEFAULT '',
+  `IP` varchar(45) COLLATE utf8_unicode_ci NOT NULL DEFAULT '',
+  PRIMARY KEY (`Id`),
+  KEY `UserSession_SessionKey` (`SessionKey`(255)),
+  KEY `UserSession_UserId` (`UserId`),
+  KEY `UserSession_LastSeenTime` (`LastSeenTime`),
+  CONSTRAINT `UserSession_User` FOREIGN KEY (`UserId`) REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
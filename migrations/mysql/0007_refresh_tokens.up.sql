@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `RefreshTokens` (
+  `TokenHash` char(64) COLLATE utf8_unicode_ci NOT NULL,
+  `SessionId` bigint NOT NULL,
+  `UserId` varchar(128) COLLATE utf8_unicode_ci NOT NULL,
+  `Created` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `ExpiresAt` datetime NOT NULL,
+  `UsedAt` datetime NULL DEFAULT NULL,
+  PRIMARY KEY (`TokenHash`),
+  KEY `RefreshTokens_SessionId` (`SessionId`),
+  KEY `RefreshTokens_ExpiresAt` (`ExpiresAt`),
+  CONSTRAINT `RefreshTokens_Session` FOREIGN KEY (`SessionId`) REFERENCES `UserSession` (`Id`) ON DELETE CASCADE,
+  CONSTRAINT `RefreshTokens_User` FOREIGN KEY (`UserId`) REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS `OneTimeTokens` (
+  `TokenHash` char(64) COLLATE utf8_unicode_ci NOT NULL,
+  `UserId` varchar(128) COLLATE utf8_unicode_ci NOT NULL,
+  `Purpose` varchar(20) COLLATE utf8_unicode_ci NOT NULL,
+  `Created` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `ExpiresAt` datetime NOT NULL,
+  `UsedAt` datetime NULL DEFAULT NULL,
+  PRIMARY KEY (`TokenHash`),
+  KEY `OneTimeTokens_UserId` (`UserId`),
+  KEY `OneTimeTokens_ExpiresAt` (`ExpiresAt`),
+  CONSTRAINT `OneTimeTokens_User` FOREIGN KEY (`UserId`) REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
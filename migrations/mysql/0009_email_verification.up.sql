@@ -0,0 +1,2 @@
+ALTER TABLE `Users` ADD COLUMN `EmailVerified` tinyint(1) NOT NULL DEFAULT 0;
+UPDATE `Users` SET `EmailVerified` = 1;
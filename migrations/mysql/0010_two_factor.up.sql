@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS `TwoFactor` (
+  `UserId` varchar(128) COLLATE utf8_unicode_ci NOT NULL,
+  `Secret` varchar(64) COLLATE utf8_unicode_ci NOT NULL,
+  `Enabled` tinyint(1) NOT NULL DEFAULT 0,
+  `LastStep` bigint NOT NULL DEFAULT 0,
+  `Created` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`UserId`),
+  CONSTRAINT `TwoFactor_User` FOREIGN KEY (`UserId`) REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+CREATE TABLE IF NOT EXISTS `RecoveryCodes` (
+  `Id` int NOT NULL AUTO_INCREMENT,
+  `UserId` varchar(128) COLLATE utf8_unicode_ci NOT NULL,
+  `CodeHash` char(64) COLLATE utf8_unicode_ci NOT NULL,
+  `UsedAt` datetime NULL DEFAULT NULL,
+  PRIMARY KEY (`Id`),
+  KEY `RecoveryCodes_UserId` (`UserId`, `CodeHash`),
+  CONSTRAINT `RecoveryCodes_User` FOREIGN KEY (`UserId`) REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
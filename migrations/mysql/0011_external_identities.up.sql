@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `ExternalIdentities` (
+  `Issuer` varchar(255) COLLATE utf8_bin NOT NULL,
+  `Subject` varchar(255) COLLATE utf8_bin NOT NULL,
+  `UserId` varchar(128) COLLATE utf8_unicode_ci NOT NULL,
+  `Email` varchar(80) COLLATE utf8_unicode_ci NOT NULL DEFAULT '',
+  `Created` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`Issuer`, `Subject`),
+  KEY `ExternalIdentities_UserId` (`UserId`),
+  CONSTRAINT `ExternalIdentities_User` FOREIGN KEY (`UserId`) REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `ApiKeys` (
+  `Id` int NOT NULL AUTO_INCREMENT,
+  `UserId` varchar(128) COLLATE utf8_unicode_ci NOT NULL,
+  `Name` varchar(80) COLLATE utf8_unicode_ci NOT NULL DEFAULT '',
+  `Prefix` varchar(16) COLLATE utf8_unicode_ci NOT NULL,
+  `KeyHash` char(64) COLLATE utf8_unicode_ci NOT NULL,
+  `Scopes` varchar(255) COLLATE utf8_unicode_ci NOT NULL DEFAULT '',
+  `Created` datetime NOT NULL,
+  `LastUsed` datetime NULL DEFAULT NULL,
+  PRIMARY KEY (`Id`),
+  UNIQUE KEY `ApiKeys_KeyHash` (`KeyHash`),
+  KEY `ApiKeys_UserId` (`UserId`),
+  CONSTRAINT `ApiKeys_User` FOREIGN KEY (`UserId`) REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `InviteCodes` (
+  `Id` int NOT NULL AUTO_INCREMENT,
+  `Prefix` varchar(16) COLLATE utf8_unicode_ci NOT NULL,
+  `CodeHash` char(64) COLLATE utf8_unicode_ci NOT NULL,
+  `MaxUses` int NOT NULL,
+  `Uses` int NOT NULL DEFAULT 0,
+  `Created` datetime NOT NULL,
+  `ExpiresAt` datetime NULL DEFAULT NULL,
+  PRIMARY KEY (`Id`),
+  UNIQUE KEY `InviteCodes_CodeHash` (`CodeHash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
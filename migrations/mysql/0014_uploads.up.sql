@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS `Uploads` (
+  `Path` varchar(255) COLLATE utf8_unicode_ci NOT NULL,
+  `UserId` varchar(128) COLLATE utf8_unicode_ci NOT NULL,
+  `Kind` varchar(20) COLLATE utf8_unicode_ci NOT NULL,
+  `Size` bigint NOT NULL DEFAULT 0,
+  `Hash` char(64) COLLATE utf8_unicode_ci NOT NULL DEFAULT '',
+  `Created` datetime NOT NULL,
+  PRIMARY KEY (`Path`),
+  KEY `Uploads_UserId` (`UserId`),
+  CONSTRAINT `Uploads_User` FOREIGN KEY (`UserId`) REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_unicode_ci;
+INSERT IGNORE INTO `Uploads` (`Path`, `UserId`, `Kind`, `Size`, `Hash`, `Created`) SELECT `Path`, `UserId`, `Kind`, 0, '', NOW() FROM `FileReferences` WHERE `Path` NOT IN ('img/profileDefault.png', 'img/backgroundDefault.png') ORDER BY `Id`;
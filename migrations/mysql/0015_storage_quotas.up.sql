@@ -0,0 +1 @@
+ALTER TABLE `Users` ADD COLUMN `StorageQuota` bigint NULL DEFAULT NULL;
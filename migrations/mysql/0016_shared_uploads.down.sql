@@ -0,0 +1,2 @@
+DELETE `Newer` FROM `Uploads` `Newer` JOIN `Uploads` `Older` ON `Newer`.`Path` = `Older`.`Path` AND `Newer`.`UserId` > `Older`.`UserId`;
+ALTER TABLE `Uploads` DROP PRIMARY KEY, ADD PRIMARY KEY (`Path`);
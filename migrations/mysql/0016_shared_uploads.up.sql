@@ -0,0 +1 @@
+ALTER TABLE `Uploads` DROP PRIMARY KEY, ADD PRIMARY KEY (`Path`, `UserId`);
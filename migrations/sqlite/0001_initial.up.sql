@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS `UserSession` (`SessionKey` varchar(512) NOT NULL,`UserId` varchar(128) NOT NULL,`LoginTime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,`LastSeenTime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP);
+CREATE TABLE IF NOT EXISTS `Users` (`EMail` varchar(80) NOT NULL COLLATE NOCASE,`UserId` varchar(128) DEFAULT '',`Password` varchar(512) DEFAULT '',`PasswordSalt` varchar(512) DEFAULT NULL,PRIMARY KEY (`EMail`));
+CREATE TABLE IF NOT EXISTS `UserContent` (`UserId` varchar(128) NOT NULL,`FullName` varchar(70) NOT NULL,`Phone` varchar(50) NOT NULL,`EMail` varchar(80) NOT NULL,`ProfileIcon` varchar(150) NOT NULL,`ProfileHeader` varchar(150) NOT NULL,`Description` varchar(360) NOT NULL,`PublicName` varchar(80) NOT NULL DEFAULT '' COLLATE NOCASE,`PDFs` varchar(20000) NOT NULL DEFAULT '');
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS `UserContent_PublicName`;
+DROP INDEX IF EXISTS `UserContent_UserId`;
+DROP INDEX IF EXISTS `Users_UserId`;
+DROP INDEX IF EXISTS `UserSession_LastSeenTime`;
+DROP INDEX IF EXISTS `UserSession_UserId`;
+DROP INDEX IF EXISTS `UserSession_SessionKey`;
@@ -0,0 +1,6 @@
+CREATE INDEX IF NOT EXISTS `UserSession_SessionKey` ON `UserSession` (`SessionKey`);
+CREATE INDEX IF NOT EXISTS `UserSession_UserId` ON `UserSession` (`UserId`);
+CREATE INDEX IF NOT EXISTS `UserSession_LastSeenTime` ON `UserSession` (`LastSeenTime`);
+CREATE INDEX IF NOT EXISTS `Users_UserId` ON `Users` (`UserId`);
+CREATE INDEX IF NOT EXISTS `UserContent_UserId` ON `UserContent` (`UserId`);
+CREATE INDEX IF NOT EXISTS `UserContent_PublicName` ON `UserContent` (`PublicName`);
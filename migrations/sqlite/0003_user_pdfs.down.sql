@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `UserPDFs`;
+DROP INDEX IF EXISTS `Users_UserId_Unique`;
@@ -0,0 +1,12 @@
+CREATE UNIQUE INDEX IF NOT EXISTS `Users_UserId_Unique` ON `Users` (`UserId`);
+CREATE TABLE IF NOT EXISTS `UserPDFs` (
+  `Id` integer PRIMARY KEY AUTOINCREMENT,
+  `UserId` varchar(128) NOT NULL REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE,
+  `Title` varchar(255) NOT NULL DEFAULT '',
+  `Path` varchar(255) NOT NULL,
+  `Position` integer NOT NULL,
+  `UploadTime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `Size` integer NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS `UserPDFs_UserId` ON `UserPDFs` (`UserId`, `Position`);
+CREATE INDEX IF NOT EXISTS `UserPDFs_Path` ON `UserPDFs` (`Path`);
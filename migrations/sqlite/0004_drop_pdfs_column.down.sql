@@ -0,0 +1 @@
+ALTER TABLE `UserContent` ADD COLUMN `PDFs` varchar(20000) NOT NULL DEFAULT '';
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `FileReferences` (
+  `Id` integer PRIMARY KEY AUTOINCREMENT,
+  `Path` varchar(255) NOT NULL,
+  `UserId` varchar(128) NOT NULL REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE,
+  `Kind` varchar(20) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS `FileReferences_Path` ON `FileReferences` (`Path`);
+CREATE INDEX IF NOT EXISTS `FileReferences_UserId` ON `FileReferences` (`UserId`);
+INSERT INTO `FileReferences` (`Path`, `UserId`, `Kind`) SELECT `ProfileIcon`, `UserId`, 'profile-icon' FROM `UserContent` WHERE `ProfileIcon` <> '' AND `UserId` IN (SELECT `UserId` FROM `Users`);
+INSERT INTO `FileReferences` (`Path`, `UserId`, `Kind`) SELECT `ProfileHeader`, `UserId`, 'profile-header' FROM `UserContent` WHERE `ProfileHeader` <> '' AND `UserId` IN (SELECT `UserId` FROM `Users`);
+INSERT INTO `FileReferences` (`Path`, `UserId`, `Kind`) SELECT `Path`, `UserId`, 'pdf' FROM `UserPDFs` WHERE `Path` <> '';
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS `UserSession`;
+CREATE TABLE `UserSession` (`SessionKey` varchar(512) NOT NULL,`UserId` varchar(128) NOT NULL,`LoginTime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,`LastSeenTime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP);
+CREATE INDEX `UserSession_SessionKey` ON `UserSession` (`SessionKey`);
+CREATE INDEX `UserSession_UserId` ON `UserSession` (`UserId`);
+CREATE INDEX `UserSession_LastSeenTime` ON `UserSession` (`LastSeenTime`);
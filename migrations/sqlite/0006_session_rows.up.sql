@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS `UserSession`;
+CREATE TABLE `UserSession` (
+  `Id` integer PRIMARY KEY AUTOINCREMENT,
+  `SessionKey` varchar(512) NOT NULL,
+  `UserId` varchar(128) NOT NULL REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE,
+  `LoginTime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `LastSeenTime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `UserAgent` varchar(255) NOT NULL DEFAULT '',
+  `IP` varchar(45) NOT NULL DEFAULT ''
+);
+CREATE INDEX `UserSession_SessionKey` ON `UserSession` (`SessionKey`);
+CREATE INDEX `UserSession_UserId` ON `UserSession` (`UserId`);
+CREATE INDEX `UserSession_LastSeenTime` ON `UserSession` (`LastSeenTime`);
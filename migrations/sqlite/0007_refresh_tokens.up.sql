@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `RefreshTokens` (
+  `TokenHash` char(64) NOT NULL PRIMARY KEY,
+  `SessionId` integer NOT NULL REFERENCES `UserSession` (`Id`) ON DELETE CASCADE,
+  `UserId` varchar(128) NOT NULL REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE,
+  `Created` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `ExpiresAt` timestamp NOT NULL,
+  `UsedAt` timestamp NULL DEFAULT NULL
+);
+CREATE INDEX `RefreshTokens_SessionId` ON `RefreshTokens` (`SessionId`);
+CREATE INDEX `RefreshTokens_ExpiresAt` ON `RefreshTokens` (`ExpiresAt`);
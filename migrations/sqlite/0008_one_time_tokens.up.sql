@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `OneTimeTokens` (
+  `TokenHash` char(64) NOT NULL PRIMARY KEY,
+  `UserId` varchar(128) NOT NULL REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE,
+  `Purpose` varchar(20) NOT NULL,
+  `Created` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `ExpiresAt` timestamp NOT NULL,
+  `UsedAt` timestamp NULL DEFAULT NULL
+);
+CREATE INDEX `OneTimeTokens_UserId` ON `OneTimeTokens` (`UserId`);
+CREATE INDEX `OneTimeTokens_ExpiresAt` ON `OneTimeTokens` (`ExpiresAt`);
@@ -0,0 +1,2 @@
+ALTER TABLE `Users` ADD COLUMN `EmailVerified` integer NOT NULL DEFAULT 0;
+UPDATE `Users` SET `EmailVerified` = 1;
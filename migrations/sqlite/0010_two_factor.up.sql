@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `TwoFactor` (
+  `UserId` varchar(128) NOT NULL PRIMARY KEY REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE,
+  `Secret` varchar(64) NOT NULL,
+  `Enabled` integer NOT NULL DEFAULT 0,
+  `LastStep` integer NOT NULL DEFAULT 0,
+  `Created` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS `RecoveryCodes` (
+  `Id` integer PRIMARY KEY AUTOINCREMENT,
+  `UserId` varchar(128) NOT NULL REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE,
+  `CodeHash` char(64) NOT NULL,
+  `UsedAt` timestamp NULL DEFAULT NULL
+);
+CREATE INDEX `RecoveryCodes_UserId` ON `RecoveryCodes` (`UserId`, `CodeHash`);
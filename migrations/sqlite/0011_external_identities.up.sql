@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `ExternalIdentities` (
+  `Issuer` varchar(255) NOT NULL,
+  `Subject` varchar(255) NOT NULL,
+  `UserId` varchar(128) NOT NULL REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE,
+  `Email` varchar(80) NOT NULL DEFAULT '',
+  `Created` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`Issuer`, `Subject`)
+);
+CREATE INDEX `ExternalIdentities_UserId` ON `ExternalIdentities` (`UserId`);
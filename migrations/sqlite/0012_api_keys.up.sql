@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `ApiKeys` (
+  `Id` integer PRIMARY KEY AUTOINCREMENT,
+  `UserId` varchar(128) NOT NULL REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE,
+  `Name` varchar(80) NOT NULL DEFAULT '',
+  `Prefix` varchar(16) NOT NULL,
+  `KeyHash` char(64) NOT NULL UNIQUE,
+  `Scopes` varchar(255) NOT NULL DEFAULT '',
+  `Created` timestamp NOT NULL,
+  `LastUsed` timestamp NULL DEFAULT NULL
+);
+CREATE INDEX `ApiKeys_UserId` ON `ApiKeys` (`UserId`);
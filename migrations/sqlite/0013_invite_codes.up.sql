@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `InviteCodes` (
+  `Id` integer PRIMARY KEY AUTOINCREMENT,
+  `Prefix` varchar(16) NOT NULL,
+  `CodeHash` char(64) NOT NULL UNIQUE,
+  `MaxUses` integer NOT NULL,
+  `Uses` integer NOT NULL DEFAULT 0,
+  `Created` timestamp NOT NULL,
+  `ExpiresAt` timestamp NULL DEFAULT NULL
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `Uploads` (
+  `Path` varchar(255) NOT NULL PRIMARY KEY,
+  `UserId` varchar(128) NOT NULL REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE,
+  `Kind` varchar(20) NOT NULL,
+  `Size` bigint NOT NULL DEFAULT 0,
+  `Hash` char(64) NOT NULL DEFAULT '',
+  `Created` timestamp NOT NULL
+);
+CREATE INDEX IF NOT EXISTS `Uploads_UserId` ON `Uploads` (`UserId`);
+INSERT OR IGNORE INTO `Uploads` (`Path`, `UserId`, `Kind`, `Size`, `Hash`, `Created`) SELECT `Path`, `UserId`, `Kind`, 0, '', CURRENT_TIMESTAMP FROM `FileReferences` WHERE `Path` NOT IN ('img/profileDefault.png', 'img/backgroundDefault.png') ORDER BY `Id`;
@@ -0,0 +1,12 @@
+CREATE TABLE `SingleUploads` (
+  `Path` varchar(255) NOT NULL PRIMARY KEY,
+  `UserId` varchar(128) NOT NULL REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE,
+  `Kind` varchar(20) NOT NULL,
+  `Size` bigint NOT NULL DEFAULT 0,
+  `Hash` char(64) NOT NULL DEFAULT '',
+  `Created` timestamp NOT NULL
+);
+INSERT OR IGNORE INTO `SingleUploads` (`Path`, `UserId`, `Kind`, `Size`, `Hash`, `Created`) SELECT `Path`, `UserId`, `Kind`, `Size`, `Hash`, `Created` FROM `Uploads` ORDER BY `UserId`;
+DROP TABLE `Uploads`;
+ALTER TABLE `SingleUploads` RENAME TO `Uploads`;
+CREATE INDEX IF NOT EXISTS `Uploads_UserId` ON `Uploads` (`UserId`);
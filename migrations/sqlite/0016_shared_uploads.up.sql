@@ -0,0 +1,13 @@
+CREATE TABLE `SharedUploads` (
+  `Path` varchar(255) NOT NULL,
+  `UserId` varchar(128) NOT NULL REFERENCES `Users` (`UserId`) ON DELETE CASCADE ON UPDATE CASCADE,
+  `Kind` varchar(20) NOT NULL,
+  `Size` bigint NOT NULL DEFAULT 0,
+  `Hash` char(64) NOT NULL DEFAULT '',
+  `Created` timestamp NOT NULL,
+  PRIMARY KEY (`Path`, `UserId`)
+);
+INSERT INTO `SharedUploads` (`Path`, `UserId`, `Kind`, `Size`, `Hash`, `Created`) SELECT `Path`, `UserId`, `Kind`, `Size`, `Hash`, `Created` FROM `Uploads`;
+DROP TABLE `Uploads`;
+ALTER TABLE `SharedUploads` RENAME TO `Uploads`;
+CREATE INDEX IF NOT EXISTS `Uploads_UserId` ON `Uploads` (`UserId`);
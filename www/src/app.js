@@ -21,6 +21,22 @@ app.config(['$routeProvider', '$locationProvider',
       controller:'SignupController',
       templateUrl:'../views/signup.html'
     })
+    .when('/password/forgot', {
+      controller:'PasswordResetController',
+      templateUrl:'../views/passwordReset.html'
+    })
+    .when('/password/reset/:token', {
+      controller:'PasswordResetController',
+      templateUrl:'../views/passwordReset.html'
+    })
+    .when('/verify/:token', {
+      controller:'VerifyEmailController',
+      templateUrl:'../views/verifyEmail.html'
+    })
+    .when('/oidc/callback', {
+      controller:'OIDCController',
+      templateUrl:'../views/oidc.html'
+    })
     .when('/profile', {
       controller:'ProfileRedirectController',
       templateUrl:'../views/frontpage.html'
@@ -29,6 +45,10 @@ app.config(['$routeProvider', '$locationProvider',
       controller:'ProfileEditController',
       templateUrl:'../views/profileEdit.html'
     })
+    .when('/profile/security', {
+      controller:'SecurityController',
+      templateUrl:'../views/security.html'
+    })
     .when('/profile/:publicName', {
       controller:'ProfileController',
       templateUrl:'../views/profile.html'
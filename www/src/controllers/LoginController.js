@@ -6,6 +6,17 @@ app.controller('LoginController', ['$scope', '$http', '$window', '$location', 't
   // Declare variables
   $scope.user = {};
   $scope.message = '';
+  $scope.provider = null;
+
+  /* Show single sign-on if the server has an identity provider */
+  $http.get('/api/oidc/provider').then(
+    function (response) {
+      $scope.provider = response.data;
+    },
+    function () {
+      $scope.provider = null;
+    }
+  );
   
   /* Submit function, sends login info (email & password) to server */
   $scope.submit = function () {
@@ -27,9 +38,15 @@ app.controller('LoginController', ['$scope', '$http', '$window', '$location', 't
             • config – {Object} – The configuration object that was used to generate the request.
             • statusText – {string} – HTTP status text of the response.
             */
-            if (response.data.Token !== undefined) {
+            if (response.data.TwoFactorToken !== undefined) {
+              /* Password was right, now the code from the authenticator app is needed */
+              $scope.twoFactorToken = response.data.TwoFactorToken;
+              $scope.message = '';
+
+            } else if (response.data.Token !== undefined) {
               /* Complete success */
               $window.sessionStorage.token = response.data.Token;
+              $window.sessionStorage.refreshToken = response.data.RefreshToken;
               tokenRefresher.start();
               
               $location.path('/profile/edit');
@@ -43,6 +60,7 @@ app.controller('LoginController', ['$scope', '$http', '$window', '$location', 't
           function (response) {
             // Erase the token if the user fails to log in
             delete $window.sessionStorage.token;
+            delete $window.sessionStorage.refreshToken;
   
             // Handle login errors here
             $scope.message = response.data;
@@ -53,4 +71,24 @@ app.controller('LoginController', ['$scope', '$http', '$window', '$location', 't
       $scope.message = 'Please fill out the form correctly';
     }
   };
-}]);
\ No newline at end of file
+
+  /* Second login step, sends the two-factor code or a recovery code */
+  $scope.submitCode = function () {
+    $http
+      .post('/api/login/2fa', {Token: $scope.twoFactorToken, Code: $scope.user.code})
+      .then(
+        function (response) {
+          $window.sessionStorage.token = response.data.Token;
+          $window.sessionStorage.refreshToken = response.data.RefreshToken;
+          tokenRefresher.start();
+          $location.path('/profile/edit');
+        },
+        function (response) {
+          // A wrong code means starting over with the password
+          delete $scope.twoFactorToken;
+          $scope.user.code = '';
+          $scope.message = response.data;
+        }
+      );
+  };
+}]);
@@ -0,0 +1,55 @@
+/**
+ * OIDCController finishes a single sign-on login when the identity
+ * provider sends the user back with a code
+ */
+app.controller('OIDCController', ['$scope', '$http', '$window', '$location', 'tokenRefresher',
+                         function ($scope,   $http,   $window,   $location,   tokenRefresher) {
+  var params = $location.search();
+  $scope.failed = false;
+  $scope.message = 'Logging you in...';
+
+  if (params.error) {
+    $scope.failed = true;
+    $scope.message = params.error_description || 'The identity provider did not log you in';
+    return;
+  }
+
+  $scope.user = {};
+
+  /* Stores the tokens and opens the profile */
+  var loggedIn = function (response) {
+    $window.sessionStorage.token = response.data.Token;
+    $window.sessionStorage.refreshToken = response.data.RefreshToken;
+    tokenRefresher.start();
+    $location.search({});
+    $location.path('/profile/edit');
+  };
+
+  var failed = function (response) {
+    delete $scope.twoFactorToken;
+    $scope.failed = true;
+    $scope.message = response.data;
+  };
+
+  $http
+    .post('/api/oidc/token', {Code: params.code, State: params.state})
+    .then(
+      function (response) {
+        if (response.data.TwoFactorToken !== undefined) {
+          /* Accounts with two-factor authentication need a code as well */
+          $scope.twoFactorToken = response.data.TwoFactorToken;
+          $scope.message = '';
+        } else {
+          loggedIn(response);
+        }
+      },
+      failed
+    );
+
+  /* Second login step, sends the two-factor code or a recovery code */
+  $scope.submitCode = function () {
+    $http
+      .post('/api/login/2fa', {Token: $scope.twoFactorToken, Code: $scope.user.code})
+      .then(loggedIn, failed);
+  };
+}]);
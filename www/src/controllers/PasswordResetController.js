@@ -0,0 +1,53 @@
+/**
+ * PasswordResetController handles both asking for a reset link and
+ * choosing a new password once the user follows the link in the mail
+ */
+app.controller('PasswordResetController', ['$scope', '$http', '$routeParams', '$location', 'toastr',
+                                  function ($scope,   $http,   $routeParams,   $location,   toastr) {
+  // Declare variables
+  $scope.user = {};
+  $scope.message = '';
+  $scope.token = $routeParams.token; // only set when following a reset link
+  $scope.sent = false;
+
+  /* Asks the server to mail a reset link */
+  $scope.forgot = function () {
+    if ($scope.user.email && $scope.user.email != '') {
+      $http
+        .post('/api/password/forgot', {Email: $scope.user.email})
+        .then(
+          function (response) {
+            $scope.sent = true;
+            $scope.message = response.data;
+          },
+          function (response) {
+            $scope.message = response.data;
+          }
+        );
+    } else {
+      $scope.formNotFilled = true;
+      $scope.message = 'Please fill out the form correctly';
+    }
+  };
+
+  /* Sends the new password together with the token from the link */
+  $scope.reset = function () {
+    if ($scope.user.password && $scope.user.password != '' &&
+        $scope.user.password == $scope.user.confirmPassword) {
+      $http
+        .post('/api/password/reset', {Token: $scope.token, Password: $scope.user.password})
+        .then(
+          function (response) {
+            toastr.success('Your password has been changed, please log in');
+            $location.path('/login');
+          },
+          function (response) {
+            $scope.message = response.data;
+          }
+        );
+    } else {
+      $scope.formNotFilled = true;
+      $scope.message = 'Please fill out the form correctly';
+    }
+  };
+}]);
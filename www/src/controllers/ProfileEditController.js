@@ -37,11 +37,13 @@ app.controller('ProfileEditController', ['$scope', '$http', '$window', '$locatio
         $http.get('/api/logout').then(
           function success(response) {
             $window.sessionStorage.removeItem('token');
+            $window.sessionStorage.removeItem('refreshToken');
             toastr.success('You have been logged out');
             $location.path('/');
           },
           function error(response) {
             $window.sessionStorage.removeItem('token');
+            $window.sessionStorage.removeItem('refreshToken');
             toastr.success('You have been logged out');
             $location.path('/');
           }
@@ -130,6 +132,9 @@ app.controller('ProfileEditController', ['$scope', '$http', '$window', '$locatio
         function error(response) {
           var oldMessage = $scope.message;
           $scope.message = 'Saved failed';
+          if (response.status == 403) {
+            $scope.message = response.data; // email has to be verified first
+          }
           $interval(function() {$scope.message = oldMessage;}, 5*1000); // 5 sec
         }
       );
@@ -0,0 +1,56 @@
+/**
+ * SecurityController lets the logged in user turn two-factor
+ * authentication on and off
+ */
+app.controller('SecurityController', ['$scope', '$http', '$window', '$location', 'toastr',
+                             function ($scope,   $http,   $window,   $location,   toastr) {
+  if ($window.sessionStorage.getItem('token') == null) {
+    $location.path('/login');
+    return;
+  }
+  $scope.code = '';
+  $scope.message = '';
+  $scope.enrollment = null;   // secret and uri while setting up
+  $scope.recoveryCodes = [];  // shown once after turning on
+
+  /* Creates a new secret to add to the authenticator app */
+  $scope.enroll = function () {
+    $http.post('/api/2fa/enroll').then(
+      function (response) {
+        $scope.enrollment = response.data;
+        $scope.message = '';
+      },
+      function (response) {
+        $scope.message = response.data;
+      }
+    );
+  };
+
+  /* Turns two-factor on with a code from the new secret */
+  $scope.confirm = function () {
+    $http.post('/api/2fa/confirm', {Code: $scope.code}).then(
+      function (response) {
+        $scope.enrollment = null;
+        $scope.recoveryCodes = response.data.RecoveryCodes;
+        $scope.code = '';
+        toastr.success('Two-factor authentication is on');
+      },
+      function (response) {
+        $scope.message = response.data;
+      }
+    );
+  };
+
+  /* Turns two-factor off, takes a current code or a recovery code */
+  $scope.disable = function () {
+    $http.post('/api/2fa/disable', {Code: $scope.code}).then(
+      function (response) {
+        $scope.code = '';
+        toastr.success(response.data);
+      },
+      function (response) {
+        $scope.message = response.data;
+      }
+    );
+  };
+}]);
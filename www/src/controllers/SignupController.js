@@ -6,6 +6,14 @@ app.controller('SignupController', ['$scope', '$http', '$window', '$location', '
   // Declare variables
   $scope.user = {};
   $scope.message = '';
+  $scope.policy = { Mode: 'open' };
+
+  /* Ask who may register, to show the invite code field or why nobody can */
+  $http.get('/api/register/policy').then(
+    function (response) {
+      $scope.policy = response.data;
+    }
+  );
   
   // Submit function
   $scope.submit = function () {
@@ -28,11 +36,14 @@ app.controller('SignupController', ['$scope', '$http', '$window', '$location', '
             */
             if (response.data.Token !== undefined) {
               $window.sessionStorage.token = response.data.Token;
+              $window.sessionStorage.refreshToken = response.data.RefreshToken;
               $scope.message = 'Logged in';
               tokenRefresher.start();
               
               $location.path('/profile/edit');
               
+            } else if (response.data) {
+              $scope.message = response.data; // account needs to be verified before logging in
             } else {
               $scope.message = 'Unable to contact server';
             }
@@ -41,6 +52,7 @@ app.controller('SignupController', ['$scope', '$http', '$window', '$location', '
           function (response) {
             // Erase the token if the user fails to log in
             delete $window.sessionStorage.token;
+            delete $window.sessionStorage.refreshToken;
   
             // Handle login errors here
             $scope.message = response.data;
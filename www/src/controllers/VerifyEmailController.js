@@ -0,0 +1,21 @@
+/**
+ * VerifyEmailController confirms the email address as soon as the
+ * user follows the verification link in the mail
+ */
+app.controller('VerifyEmailController', ['$scope', '$http', '$routeParams',
+                                function ($scope,   $http,   $routeParams) {
+  $scope.message = 'Verifying your email...';
+  $scope.verified = false;
+
+  $http
+    .post('/api/email/verify', {Token: $routeParams.token})
+    .then(
+      function (response) {
+        $scope.verified = true;
+        $scope.message = response.data;
+      },
+      function (response) {
+        $scope.message = response.data;
+      }
+    );
+}]);
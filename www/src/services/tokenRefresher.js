@@ -34,10 +34,19 @@ app.factory('tokenRefresher', ['$interval', '$http', '$window', '$location', '$r
   };
 
   /**
-   * refresh makes a one time refresh of the token
+   * refresh makes a one time refresh of the token. The refresh token is
+   * traded for a new pair when we have one, so an expired access token
+   * (e.g. after the computer has been asleep) does not log the user out
    */
   var refresh = function() {
-    $http.get('/api/refreshtoken').then(
+    var refreshToken = $window.sessionStorage.getItem('refreshToken');
+    var request;
+    if (refreshToken != undefined) {
+      request = $http.post('/api/token/refresh', {RefreshToken: refreshToken});
+    } else {
+      request = $http.get('/api/refreshtoken');
+    }
+    request.then(
       function success(response) {
         /* The response object has these properties:
 
@@ -50,6 +59,9 @@ app.factory('tokenRefresher', ['$interval', '$http', '$window', '$location', '$r
         if (response.data.Token !== undefined) {
           /* Complete success */
           $window.sessionStorage.setItem('token', response.data.Token);
+          if (response.data.RefreshToken !== undefined) {
+            $window.sessionStorage.setItem('refreshToken', response.data.RefreshToken);
+          }
 
         } else {
           /* No server response */
@@ -60,6 +72,7 @@ app.factory('tokenRefresher', ['$interval', '$http', '$window', '$location', '$r
               onTap: function() {$location.path('/login')}
             });
             $window.sessionStorage.removeItem('token');
+            $window.sessionStorage.removeItem('refreshToken');
             stop();
             $rootScope.$emit('refreshtoken-relogin'); // trigger relogin event for all subscribers
 
@@ -74,6 +87,7 @@ app.factory('tokenRefresher', ['$interval', '$http', '$window', '$location', '$r
         $window.sessionStorage.removeItem('token', {
               onTap: function() {$location.path('/login')}
           });
+        $window.sessionStorage.removeItem('refreshToken');
         stop();
         $rootScope.$emit('refreshtoken-relogin'); // trigger relogin event for all subscribers
       }